@@ -0,0 +1,130 @@
+// Package acl implements CIDR based access control lists for DNS clients
+// and the admin interface.
+package acl
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// List is a set of address prefixes. A nil List matches every address.
+type List struct {
+	prefixes []netip.Prefix
+}
+
+// Parse reads a comma or space separated list of CIDRs and single
+// addresses. The keywords "any" and "none" match every and no address.
+// An empty string yields def.
+func Parse(s string, def *List) (*List, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return def, nil
+	}
+
+	l := &List{}
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		switch strings.ToLower(f) {
+		case "any":
+			return nil, nil
+		case "none":
+			continue
+		}
+
+		if strings.Contains(f, "/") {
+			p, err := netip.ParsePrefix(f)
+			if err != nil {
+				return nil, fmt.Errorf("acl: invalid prefix %q: %w", f, err)
+			}
+			l.prefixes = append(l.prefixes, p.Masked())
+			continue
+		}
+
+		ip, err := netip.ParseAddr(f)
+		if err != nil {
+			return nil, fmt.Errorf("acl: invalid address %q: %w", f, err)
+		}
+		l.prefixes = append(l.prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+	}
+	return l, nil
+}
+
+// None returns a list that matches no address.
+func None() *List {
+	return &List{}
+}
+
+func (l *List) Contains(ip netip.Addr) bool {
+	if l == nil {
+		return true
+	}
+	ip = ip.Unmap()
+	for _, p := range l.prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *List) String() string {
+	if l == nil {
+		return "any"
+	}
+	if len(l.prefixes) == 0 {
+		return "none"
+	}
+	parts := make([]string, len(l.prefixes))
+	for i, p := range l.prefixes {
+		parts[i] = p.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// Middleware rejects HTTP requests whose remote address is not in l with
+// 403 Forbidden.
+func (l *List) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip, err := netip.ParseAddr(host)
+		if err != nil || !l.Contains(ip) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ACL groups the lists applied to DNS clients.
+type ACL struct {
+	Query     *List // چه کسانی اصلاً می‌توانند پرسش کنند
+	Recursion *List // چه کسانی به upstream دسترسی دارند
+	Transfer  *List // AXFR/IXFR و UPDATE
+}
+
+// Default allows queries and recursion from anywhere and transfers from
+// nowhere, which matches the server's behaviour before ACLs existed.
+func Default() *ACL {
+	return &ACL{
+		Query:     nil,
+		Recursion: nil,
+		Transfer:  None(),
+	}
+}
+
+func (a *ACL) AllowQuery(ip netip.Addr) bool {
+	return a == nil || a.Query.Contains(ip)
+}
+
+func (a *ACL) AllowRecursion(ip netip.Addr) bool {
+	return a == nil || a.Recursion.Contains(ip)
+}
+
+func (a *ACL) AllowTransfer(ip netip.Addr) bool {
+	return a != nil && a.Transfer.Contains(ip)
+}
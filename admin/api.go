@@ -1,8 +1,13 @@
 package admin
 
 import (
+	"dns-server/dnsname"
+	"dns-server/group"
+	"dns-server/rdata"
+	"dns-server/rewrite"
 	"dns-server/types"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -15,6 +20,11 @@ type Server struct {
 	hashed_password string
 
 	sessions map[string]time.Time
+	stats    map[string]func() any
+	views    []string
+	groups   *group.Set
+	rewrites *rewrite.Engine
+	ptrZones []string
 }
 
 func New(store types.Storage, hashed_password string) *Server {
@@ -22,9 +32,47 @@ func New(store types.Storage, hashed_password string) *Server {
 		store:           store,
 		hashed_password: hashed_password,
 		sessions:        make(map[string]time.Time),
+		stats:           make(map[string]func() any),
+		views:           []string{""},
 	}
 }
 
+// SetViews sets the view names records can be assigned to. The default
+// view (the empty name) always stays valid, so records stored in it before
+// views were configured can still be managed.
+func (s *Server) SetViews(names []string) {
+	s.views = []string{""}
+	for _, n := range names {
+		if n != "" {
+			s.views = append(s.views, n)
+		}
+	}
+}
+
+// SetGroups enables management of client filtering groups.
+func (s *Server) SetGroups(g *group.Set) {
+	s.groups = g
+}
+
+// SetRewrites enables management of custom rewrite rules.
+func (s *Server) SetRewrites(e *rewrite.Engine) {
+	s.rewrites = e
+}
+
+func (s *Server) hasView(name string) bool {
+	for _, v := range s.views {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// AddStats registers a counter source shown under name by /admin/stats.
+func (s *Server) AddStats(name string, fn func() any) {
+	s.stats[name] = fn
+}
+
 func (s *Server) isAdmin(r *http.Request) bool {
 	c, err := r.Cookie("session")
 	if err != nil {
@@ -47,6 +95,11 @@ func (s *Server) Register(mux *http.ServeMux) {
 
 	mux.HandleFunc("/", (s.handleUI))
 	mux.HandleFunc("/admin/records", s.handleRecords)
+	mux.HandleFunc("/admin/stats", s.handleStats)
+	mux.HandleFunc("/admin/views", s.handleViews)
+	mux.HandleFunc("/admin/groups", s.handleGroups)
+	mux.HandleFunc("/groups", s.handleGroupsUI)
+	mux.HandleFunc("/admin/rewrites", s.handleRewrites)
 }
 
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -135,7 +188,20 @@ func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 
 	case http.MethodGet:
-		records := s.store.List()
+		type record struct {
+			types.DNSRecord
+			Data    rdata.RData `json:",omitempty"` // شکل ساخت‌یافته Value
+			Unicode string      `json:",omitempty"` // نام با برچسب‌های یونیکد، اگر punycode دارد
+		}
+		records := []record{}
+		for _, rec := range s.store.List() {
+			data, _ := rdata.Parse(rec.Type, rec.Value)
+			var unicode string
+			if u := dnsname.Unicode(rec.Name); u != rec.Name {
+				unicode = u
+			}
+			records = append(records, record{rec, data, unicode})
+		}
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(records)
 
@@ -143,8 +209,11 @@ func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request) {
 		var req struct {
 			Name  string           `json:"name"`
 			Type  types.RecordType `json:"type"`
+			View  string           `json:"view"`
 			Value string           `json:"value"`
+			Data  json.RawMessage  `json:"data"` // به جای value، مثل {"priority":10,...}
 			TTL   uint32           `json:"ttl"`
+			PTR   bool             `json:"ptr"` // ساخت خودکار رکورد PTR
 		}
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -152,20 +221,46 @@ func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if !s.hasView(req.View) {
+			http.Error(w, "unknown view", http.StatusBadRequest)
+			return
+		}
+
+		// نام یونیکد به punycode تبدیل می‌شود
+		name, err := dnsname.Canonical(req.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		value, err := recordValue(req.Type, req.Value, req.Data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
 		rec := types.DNSRecord{
-			Name:  req.Name,
+			Name:  name,
 			Type:  req.Type,
-			Value: req.Value,
+			View:  req.View,
+			Value: value,
 			TTL:   req.TTL,
+			Local: true,
 		}
 
 		s.store.Set(rec)
+		if req.PTR || s.autoPTR(rec.Name) {
+			if ptr, ok := reverseRecord(rec); ok {
+				s.store.Set(ptr)
+			}
+		}
 		w.WriteHeader(http.StatusCreated)
 
 	case http.MethodDelete:
 		var req struct {
 			Name  string           `json:"name"`
 			Type  types.RecordType `json:"type"`
+			View  string           `json:"view"`
 			Value string           `json:"value"`
 		}
 
@@ -174,7 +269,174 @@ func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		s.store.Delete(req.Name, req.Type, req.Value)
+		name, err := dnsname.Canonical(req.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req.Name = name
+
+		// مقدار به همان شکلی که ذخیره شده تبدیل می‌شود
+		if value, err := rdata.Canonical(req.Type, req.Value); err == nil {
+			req.Value = value
+		}
+		// مقادیر ذخیره‌شده پیش از حذف خوانده می‌شوند تا PTRهای ساخته‌شده برای
+		// همه‌ی آن‌ها (در حذف بدون مقدار) پیدا شوند
+		stored, _ := s.store.Get(types.DNSQuestion{Name: req.Name, Type: req.Type, View: req.View})
+		s.store.Delete(req.View, req.Name, req.Type, req.Value)
+
+		for _, rec := range stored {
+			if req.Value == "" || rec.Value == req.Value {
+				s.deleteReverse(rec)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// recordValue validates a record's data, given either as presentation text
+// or as a structured value, and returns its canonical text.
+func recordValue(t types.RecordType, value string, data json.RawMessage) (string, error) {
+	if len(data) == 0 {
+		return rdata.Canonical(t, value)
+	}
+	r := rdata.New(t)
+	if err := json.Unmarshal(data, r); err != nil {
+		return "", fmt.Errorf("invalid data: %w", err)
+	}
+	return rdata.Validate(t, r)
+}
+
+func (s *Server) handleViews(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.isAdmin(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.views)
+}
+
+func (s *Server) handleGroupsUI(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, "static/groups.html")
+}
+
+func (s *Server) handleGroups(w http.ResponseWriter, r *http.Request) {
+	// گروه‌ها شامل آدرس کلاینت‌ها هستند و فقط برای admin نمایش داده می‌شوند
+	if !s.isAdmin(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if s.groups == nil {
+		http.Error(w, "groups are not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.groups.List())
+
+	case http.MethodPost:
+		var g group.Group
+		if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.groups.Put(g); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodDelete:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		ok, err := s.groups.Delete(req.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "unknown group", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleRewrites(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+		if !s.isAdmin(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	if s.rewrites == nil {
+		http.Error(w, "rewrites are not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.rewrites.Config())
+
+	case http.MethodPost:
+		var rule rewrite.Rule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		if err := s.rewrites.Put(rule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+
+	case http.MethodDelete:
+		var req struct {
+			Name  string `json:"name"`
+			Match string `json:"match"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		ok, err := s.rewrites.Delete(req.Name, req.Match)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.Error(w, "unknown rule", http.StatusNotFound)
+			return
+		}
 		w.WriteHeader(http.StatusNoContent)
 
 	default:
@@ -182,6 +444,26 @@ func (s *Server) handleRecords(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !s.isAdmin(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	out := make(map[string]any, len(s.stats))
+	for name, fn := range s.stats {
+		out[name] = fn()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
 func check_hashed_password(hashedPassword string, password string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) == nil
 }
@@ -0,0 +1,73 @@
+package admin
+
+import (
+	"dns-server/dnsname"
+	"dns-server/types"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// SetAutoPTR sets the forward zones whose A and AAAA records get a PTR
+// record automatically, e.g. "example.com.".
+func (s *Server) SetAutoPTR(zones []string) {
+	s.ptrZones = nil
+	for _, z := range zones {
+		if z = strings.TrimSpace(z); z != "" && z != "." {
+			s.ptrZones = append(s.ptrZones, dnsname.Fold(z))
+		}
+	}
+}
+
+func (s *Server) autoPTR(name string) bool {
+	name = dnsname.Fold(name)
+	for _, z := range s.ptrZones {
+		if name == z || strings.HasSuffix(name, "."+z) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	typeA    = types.RecordType(dnsmessage.TypeA)
+	typeAAAA = types.RecordType(dnsmessage.TypeAAAA)
+	typePTR  = types.RecordType(dnsmessage.TypePTR)
+)
+
+// reverseRecord returns the PTR record generated for an A or AAAA record.
+func reverseRecord(rec types.DNSRecord) (types.DNSRecord, bool) {
+	if rec.Type != typeA && rec.Type != typeAAAA {
+		return types.DNSRecord{}, false
+	}
+	ip, err := netip.ParseAddr(rec.Value)
+	if err != nil || ip.Unmap().Is4() != (rec.Type == typeA) {
+		return types.DNSRecord{}, false
+	}
+	return types.DNSRecord{
+		Name:      types.ReverseName(ip),
+		Type:      typePTR,
+		View:      rec.View,
+		Value:     dnsname.Fold(rec.Name),
+		TTL:       rec.TTL,
+		Local:     rec.Local,
+		Generated: true,
+	}, true
+}
+
+// deleteReverse removes the PTR record generated for rec. A PTR with the
+// same data that was added by hand stays.
+func (s *Server) deleteReverse(rec types.DNSRecord) {
+	ptr, ok := reverseRecord(rec)
+	if !ok {
+		return
+	}
+	stored, _ := s.store.Get(types.DNSQuestion{Name: ptr.Name, Type: ptr.Type, View: ptr.View})
+	for _, p := range stored {
+		if p.Generated && p.Value == ptr.Value {
+			s.store.Delete(ptr.View, ptr.Name, ptr.Type, ptr.Value)
+			return
+		}
+	}
+}
@@ -0,0 +1,378 @@
+// Package blocklist filters queries against domain block lists in hosts,
+// plain and Adblock formats, loaded from files or fetched over HTTP.
+package blocklist
+
+import (
+	"context"
+	"dns-server/dnsname"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxLists is the number of lists a filter can hold; list membership of a
+// domain is stored as a bit mask.
+const maxLists = 64
+
+const maxDownload = 256 << 20
+
+type Source struct {
+	Name     string `json:"name"`
+	URL      string `json:"url,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Category string `json:"category,omitempty"`
+}
+
+type Config struct {
+	Refresh    string   `json:"refresh"`  // مثلاً "24h"
+	Response   string   `json:"response"` // nxdomain، null یا آدرس‌های sinkhole
+	TTL        uint32   `json:"ttl"`
+	Lists      []Source `json:"lists"`
+	Allowlists []Source `json:"allowlists"`
+	Allow      []string `json:"allow"`
+	Block      []string `json:"block"`
+}
+
+// LoadConfig reads a JSON blocking configuration file.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("blocklist: %s: %w", path, err)
+	}
+	return c, nil
+}
+
+type List struct {
+	Source
+	bit uint64
+
+	entries atomic.Int64
+	blocked atomic.Uint64
+	mu      sync.Mutex
+	updated time.Time
+	err     error
+}
+
+type Filter struct {
+	refresh time.Duration
+	mode    Mode
+	sink4   []netip.Addr
+	sink6   []netip.Addr
+	ttl     uint32
+
+	lists      []*List
+	allowlists []Source
+	allow      []string
+	block      []string
+
+	mu      sync.RWMutex
+	blocked map[string]uint64 // دامنه -> بیت‌های فهرست‌هایی که آن را دارند
+	allowed map[string]struct{}
+
+	queries atomic.Uint64
+	hits    atomic.Uint64
+	client  *http.Client
+}
+
+// customList holds the domains from Config.Block.
+const customList = "custom"
+
+func New(c Config) (*Filter, error) {
+	f := &Filter{
+		refresh:    24 * time.Hour,
+		ttl:        c.TTL,
+		allowlists: c.Allowlists,
+		allow:      c.Allow,
+		block:      c.Block,
+		blocked:    make(map[string]uint64),
+		allowed:    make(map[string]struct{}),
+		client:     &http.Client{Timeout: time.Minute},
+	}
+	if f.ttl == 0 {
+		f.ttl = 10
+	}
+
+	if c.Refresh != "" {
+		d, err := time.ParseDuration(c.Refresh)
+		if err != nil {
+			return nil, fmt.Errorf("blocklist: refresh: %w", err)
+		}
+		f.refresh = d
+	}
+
+	var err error
+	if f.mode, f.sink4, f.sink6, err = parseResponse(c.Response); err != nil {
+		return nil, err
+	}
+
+	sources := c.Lists
+	if len(c.Block) > 0 {
+		sources = append(sources, Source{Name: customList})
+	}
+	if len(sources) > maxLists {
+		return nil, fmt.Errorf("blocklist: at most %d lists are supported", maxLists)
+	}
+
+	seen := make(map[string]bool)
+	for i, s := range sources {
+		if s.Name == "" || seen[s.Name] {
+			return nil, fmt.Errorf("blocklist: missing or duplicate list name %q", s.Name)
+		}
+		if s.Name != customList && (s.URL == "") == (s.Path == "") {
+			return nil, fmt.Errorf("blocklist: list %s needs exactly one of url or path", s.Name)
+		}
+		seen[s.Name] = true
+		l := &List{Source: s, bit: 1 << i}
+		f.lists = append(f.lists, l)
+	}
+
+	return f, nil
+}
+
+// Run loads every list and reloads them on the configured schedule until
+// ctx is cancelled.
+func (f *Filter) Run(ctx context.Context) {
+	f.Reload(ctx)
+
+	ticker := time.NewTicker(f.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			f.Reload(ctx)
+		}
+	}
+}
+
+// Reload fetches all lists and swaps in the new index. A list that fails
+// to load keeps its previous entries.
+func (f *Filter) Reload(ctx context.Context) {
+	f.mu.RLock()
+	old := f.blocked
+	f.mu.RUnlock()
+
+	blocked := make(map[string]uint64, len(old))
+	allowed := make(map[string]struct{})
+
+	for _, d := range f.allow {
+		if d, ok := normalize(d); ok {
+			allowed[d] = struct{}{}
+		}
+	}
+
+	for _, l := range f.lists {
+		var n int64
+		add := func(e entry) {
+			if e.allow {
+				allowed[e.domain] = struct{}{}
+				return
+			}
+			if blocked[e.domain]&l.bit == 0 {
+				n++
+			}
+			blocked[e.domain] |= l.bit
+		}
+
+		var err error
+		if l.Name == customList {
+			for _, d := range f.block {
+				if d, ok := normalize(d); ok {
+					add(entry{domain: d})
+				}
+			}
+		} else {
+			err = f.load(ctx, l.Source, add)
+		}
+
+		l.mu.Lock()
+		l.err = err
+		if err == nil {
+			l.updated = time.Now()
+		}
+		l.mu.Unlock()
+
+		if err != nil {
+			log.Printf("blocklist %s: %v (keeping previous entries)", l.Name, err)
+			for d, bits := range old {
+				if bits&l.bit != 0 {
+					blocked[d] |= l.bit
+				}
+			}
+			continue
+		}
+		l.entries.Store(n)
+	}
+
+	for _, s := range f.allowlists {
+		err := f.load(ctx, s, func(e entry) {
+			allowed[e.domain] = struct{}{}
+		})
+		if err != nil {
+			log.Printf("allowlist %s: %v", s.Name, err)
+		}
+	}
+
+	f.mu.Lock()
+	f.blocked = blocked
+	f.allowed = allowed
+	f.mu.Unlock()
+
+	log.Printf("blocklist: %d domains blocked, %d allowed", len(blocked), len(allowed))
+}
+
+func (f *Filter) load(ctx context.Context, s Source, fn func(entry)) error {
+	if s.Path != "" {
+		file, err := os.Open(s.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return parse(file, fn)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("http status " + resp.Status)
+	}
+	return parse(io.LimitReader(resp.Body, maxDownload), fn)
+}
+
+// Match reports whether name or one of its parent domains is blocked by a
+// list whose bit is set in mask, and not allowed. It returns the first
+// matching list.
+func (f *Filter) Match(name string, mask uint64) (*List, bool) {
+	f.queries.Add(1)
+
+	name = strings.TrimSuffix(dnsname.Fold(name), ".")
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	// از دامنه کامل به سمت والدها؛ allowlist بر blocklist مقدم است
+	var hit uint64
+	for d := name; d != ""; {
+		if _, ok := f.allowed[d]; ok {
+			return nil, false
+		}
+		if hit == 0 {
+			hit = f.blocked[d] & mask
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+	if hit == 0 {
+		return nil, false
+	}
+
+	for _, l := range f.lists {
+		if hit&l.bit != 0 {
+			l.blocked.Add(1)
+			f.hits.Add(1)
+			return l, true
+		}
+	}
+	return nil, false
+}
+
+// All is the mask selecting every list.
+const All = ^uint64(0)
+
+// Mask returns the bits of the lists named in names or belonging to one of
+// categories, for use with Match.
+func (f *Filter) Mask(names, categories []string) uint64 {
+	var mask uint64
+	for _, l := range f.lists {
+		if slices.Contains(names, l.Name) || l.Category != "" && slices.Contains(categories, l.Category) {
+			mask |= l.bit
+		}
+	}
+	return mask
+}
+
+// Check returns an error for a name in names that is not a list, or an
+// entry of categories that no list belongs to. Mask ignores such names.
+func (f *Filter) Check(names, categories []string) error {
+	for _, name := range names {
+		if !slices.ContainsFunc(f.lists, func(l *List) bool { return l.Name == name }) {
+			return fmt.Errorf("unknown list %q", name)
+		}
+	}
+	for _, c := range categories {
+		if !slices.ContainsFunc(f.lists, func(l *List) bool { return l.Category == c }) {
+			return fmt.Errorf("unknown category %q", c)
+		}
+	}
+	return nil
+}
+
+type ListStats struct {
+	Name     string    `json:"name"`
+	Category string    `json:"category,omitempty"`
+	Entries  int64     `json:"entries"`
+	Blocked  uint64    `json:"blocked"`
+	Updated  time.Time `json:"updated"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type Stats struct {
+	Queries uint64      `json:"queries"`
+	Blocked uint64      `json:"blocked"`
+	Domains int         `json:"domains"`
+	Allowed int         `json:"allowed"`
+	Lists   []ListStats `json:"lists"`
+}
+
+func (f *Filter) Stats() Stats {
+	f.mu.RLock()
+	st := Stats{
+		Queries: f.queries.Load(),
+		Blocked: f.hits.Load(),
+		Domains: len(f.blocked),
+		Allowed: len(f.allowed),
+	}
+	f.mu.RUnlock()
+
+	for _, l := range f.lists {
+		l.mu.Lock()
+		ls := ListStats{
+			Name:     l.Name,
+			Category: l.Category,
+			Entries:  l.entries.Load(),
+			Blocked:  l.blocked.Load(),
+			Updated:  l.updated,
+		}
+		if l.err != nil {
+			ls.Error = l.err.Error()
+		}
+		l.mu.Unlock()
+		st.Lists = append(st.Lists, ls)
+	}
+	return st
+}
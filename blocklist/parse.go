@@ -0,0 +1,120 @@
+package blocklist
+
+import (
+	"bufio"
+	"dns-server/dnsname"
+	"io"
+	"net/netip"
+	"strings"
+)
+
+// entry is one domain taken from a list line. allow is set for Adblock
+// exception rules (@@||domain^).
+type entry struct {
+	domain string
+	allow  bool
+}
+
+var hostsIgnored = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// parse reads a list in hosts-file, plain domain or Adblock format. The
+// format is detected per line, so mixed files work too.
+func parse(r io.Reader, fn func(entry)) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' || line[0] == '[' {
+			continue
+		}
+
+		// قالب Adblock
+		if strings.HasPrefix(line, "||") || strings.HasPrefix(line, "@@||") {
+			if e, ok := parseAdblock(line); ok {
+				fn(e)
+			}
+			continue
+		}
+
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		// قالب hosts: آدرس و سپس یک یا چند نام
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			for _, f := range fields[1:] {
+				if d, ok := normalize(f); ok && !hostsIgnored[d] {
+					fn(entry{domain: d})
+				}
+			}
+			continue
+		}
+
+		// فهرست ساده دامنه
+		if len(fields) == 1 {
+			if d, ok := normalize(fields[0]); ok {
+				fn(entry{domain: d})
+			}
+		}
+	}
+	return sc.Err()
+}
+
+func parseAdblock(line string) (entry, bool) {
+	e := entry{}
+	if strings.HasPrefix(line, "@@") {
+		e.allow = true
+		line = line[2:]
+	}
+	line = strings.TrimPrefix(line, "||")
+
+	rule, opts, _ := strings.Cut(line, "$")
+	// قواعد با گزینه‌های دیگر به سطح DNS مربوط نیستند
+	if opts != "" && opts != "important" {
+		return e, false
+	}
+
+	rule, ok := strings.CutSuffix(rule, "^")
+	if !ok || strings.ContainsAny(rule, "/*|^") {
+		return e, false
+	}
+
+	e.domain, ok = normalize(rule)
+	return e, ok
+}
+
+// normalize returns the canonical domain of a list entry without the
+// trailing dot, dropping a leading "*." wildcard since every entry already
+// matches its subdomains. Internationalized names become A-labels.
+func normalize(d string) (string, bool) {
+	d, err := dnsname.Canonical(strings.TrimPrefix(d, "*."))
+	if err != nil || d == "." {
+		return "", false
+	}
+	d = strings.TrimSuffix(d, ".")
+	for i := 0; i < len(d); i++ {
+		c := d[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return "", false
+		}
+	}
+	return d, true
+}
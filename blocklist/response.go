@@ -0,0 +1,70 @@
+package blocklist
+
+import (
+	"dns-server/types"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Mode selects how blocked queries are answered.
+type Mode int
+
+const (
+	ModeNXDomain Mode = iota // NXDOMAIN
+	ModeNull                 // 0.0.0.0 و ::
+	ModeSinkhole             // آدرس‌های تنظیم‌شده
+)
+
+func parseResponse(s string) (Mode, []netip.Addr, []netip.Addr, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "nxdomain":
+		return ModeNXDomain, nil, nil, nil
+	case "null", "0.0.0.0":
+		return ModeNull, []netip.Addr{netip.IPv4Unspecified()}, []netip.Addr{netip.IPv6Unspecified()}, nil
+	}
+
+	var v4, v6 []netip.Addr
+	for _, f := range strings.Split(s, ",") {
+		ip, err := netip.ParseAddr(strings.TrimSpace(f))
+		if err != nil {
+			return 0, nil, nil, fmt.Errorf("blocklist: invalid response %q", s)
+		}
+		if ip.Is4() {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+	return ModeSinkhole, v4, v6, nil
+}
+
+// Answer returns the records to send for a blocked query. nxdomain is set
+// when the query should get NXDOMAIN instead; an empty record list with
+// nxdomain unset means NODATA.
+func (f *Filter) Answer(q types.DNSQuestion) (records []types.DNSRecord, nxdomain bool) {
+	if f.mode == ModeNXDomain {
+		return nil, true
+	}
+
+	var addrs []netip.Addr
+	switch q.Type {
+	case types.RecordType(dnsmessage.TypeA):
+		addrs = f.sink4
+	case types.RecordType(dnsmessage.TypeAAAA):
+		addrs = f.sink6
+	}
+
+	for _, ip := range addrs {
+		records = append(records, types.DNSRecord{
+			Name:  q.Name,
+			Type:  q.Type,
+			View:  q.View,
+			Value: ip.String(),
+			TTL:   f.ttl,
+		})
+	}
+	return records, false
+}
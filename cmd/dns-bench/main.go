@@ -0,0 +1,103 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func buildQuery(id uint16, name string) ([]byte, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               id,
+			RecursionDesired: true,
+		},
+		Questions: []dnsmessage.Question{
+			{
+				Name:  qname,
+				Type:  dnsmessage.TypeA,
+				Class: dnsmessage.ClassINET,
+			},
+		},
+	}
+	return msg.Pack()
+}
+
+func main() {
+	server := flag.String("server", "127.0.0.1:8053", "UDP DNS server host:port")
+	name := flag.String("name", "example.com", "domain name to query")
+	workers := flag.Int("c", 64, "concurrent clients")
+	duration := flag.Duration("d", 10*time.Second, "test duration")
+	timeout := flag.Duration("timeout", time.Second, "per-query timeout")
+	flag.Parse()
+
+	var sent, answered, mismatched atomic.Int64
+	deadline := time.Now().Add(*duration)
+
+	var wg sync.WaitGroup
+	for w := 0; w < *workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			conn, err := net.Dial("udp", *server)
+			if err != nil {
+				fmt.Println("dial:", err)
+				return
+			}
+			defer conn.Close()
+
+			buf := make([]byte, 4096)
+			for id := uint16(w); time.Now().Before(deadline); id++ {
+				packet, err := buildQuery(id, *name+".")
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+
+				sent.Add(1)
+				conn.SetDeadline(time.Now().Add(*timeout))
+				if _, err := conn.Write(packet); err != nil {
+					continue
+				}
+
+				n, err := conn.Read(buf)
+				if err != nil {
+					continue
+				}
+
+				// پاسخ باید با همان شناسه و همان سؤال برگردد
+				var p dnsmessage.Parser
+				h, err := p.Start(buf[:n])
+				if err != nil || h.ID != id {
+					mismatched.Add(1)
+					continue
+				}
+				q, err := p.Question()
+				if err != nil || q.Name.String() != *name+"." {
+					mismatched.Add(1)
+					continue
+				}
+				answered.Add(1)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	secs := duration.Seconds()
+	fmt.Printf("sent:       %d\n", sent.Load())
+	fmt.Printf("answered:   %d\n", answered.Load())
+	fmt.Printf("mismatched: %d\n", mismatched.Load())
+	fmt.Printf("lost:       %d\n", sent.Load()-answered.Load()-mismatched.Load())
+	fmt.Printf("qps:        %.0f\n", float64(answered.Load())/secs)
+}
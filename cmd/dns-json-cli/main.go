@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"crypto/tls"
+	"dns-server/dnsname"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -24,6 +25,13 @@ func main() {
 		os.Exit(1)
 	}
 
+	// نام یونیکد به punycode تبدیل می‌شود
+	qname, err := dnsname.Canonical(*name)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	client := &http.Client{}
 	if *useHTTPS {
 		client.Transport = &http.Transport{
@@ -37,16 +45,15 @@ func main() {
 	}
 
 	var resp *http.Response
-	var err error
 
 	switch *method {
 	case "get":
-		fullURL := fmt.Sprintf("%s://%s?name=%s&type=%s", scheme, *url, *name, *type_)
+		fullURL := fmt.Sprintf("%s://%s?name=%s&type=%s", scheme, *url, qname, *type_)
 		resp, err = client.Get(fullURL)
 
 	case "post":
 		bodyJSON, _ := json.Marshal(map[string]string{
-			"name": *name,
+			"name": qname,
 			"type": *type_,
 		})
 		req, _ := http.NewRequest(http.MethodPost, scheme+"://"+*url, bytes.NewReader(bodyJSON))
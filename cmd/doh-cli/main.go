@@ -8,9 +8,11 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"strings"
 
 	"crypto/tls"
+	"dns-server/dnsname"
+	"dns-server/rdata"
+	"dns-server/types"
 
 	"golang.org/x/net/dns/dnsmessage"
 )
@@ -20,13 +22,17 @@ func buildQuery(name string, type_ string) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
 	msg := dnsmessage.Message{
 		Header: dnsmessage.Header{
 			ID: 1,
 		},
 		Questions: []dnsmessage.Question{
 			{
-				Name:  dnsmessage.MustNewName(name),
+				Name:  qname,
 				Type:  Type,
 				Class: dnsmessage.ClassINET,
 			},
@@ -48,7 +54,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	packet, err := buildQuery(*name+".", *type_)
+	// نام یونیکد به punycode تبدیل می‌شود
+	qname, err := dnsname.Canonical(*name)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	packet, err := buildQuery(qname, *type_)
 	if err != nil {
 		panic(err)
 	}
@@ -110,28 +123,21 @@ func main() {
 	p.SkipAllQuestions()
 	answers, _ := p.AllAnswers()
 	for _, a := range answers {
-		fmt.Println(a.Header.Name, a.Header.Type, a.Header.TTL)
-		// resource, ok := a.Body.(*dnsmessage.AResource) // برای دسترسی به مقدار
+		value := ""
+		if data, err := rdata.FromBody(a.Body); err == nil {
+			value = data.String()
+		} else {
+			value = err.Error()
+		}
+		owner := a.Header.Name.String()
+		if u := dnsname.Unicode(owner); u != owner {
+			owner += " (" + u + ")"
+		}
+		fmt.Println(owner, rdata.TypeName(types.RecordType(a.Header.Type)), a.Header.TTL, value)
 	}
 }
 
 func parseType(t string) (dnsmessage.Type, error) {
-	switch strings.ToUpper(t) {
-	case "A":
-		return dnsmessage.TypeA, nil
-	case "AAAA":
-		return dnsmessage.TypeAAAA, nil
-	case "CNAME":
-		return dnsmessage.TypeCNAME, nil
-	case "MX":
-		return dnsmessage.TypeMX, nil
-	case "TXT":
-		return dnsmessage.TypeTXT, nil
-	case "NS":
-		return dnsmessage.TypeNS, nil
-	case "PTR":
-		return dnsmessage.TypePTR, nil
-	default:
-		return 0, fmt.Errorf("unsupported type")
-	}
+	typ, err := rdata.ParseType(t)
+	return dnsmessage.Type(typ), err
 }
@@ -1,17 +1,37 @@
 package main
 
 import (
+	"context"
+	"dns-server/acl"
 	"dns-server/admin"
+	"dns-server/blocklist"
+	"dns-server/group"
+	"dns-server/hosts"
+	"dns-server/identity"
 	"dns-server/resolver"
+	"dns-server/rewrite"
+	"dns-server/rpz"
+	"dns-server/rrl"
 	"dns-server/storage"
 	"dns-server/transport"
+	"dns-server/types"
 	"dns-server/upstream"
+	"dns-server/view"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 func main() {
@@ -20,10 +40,14 @@ func main() {
 	udpPort := os.Getenv("UDP_PORT")
 	tcpPort := os.Getenv("TCP_PORT")
 	dohPort := os.Getenv("DOH_PORT")
+	dotPort := os.Getenv("DOT_PORT")
+	doqPort := os.Getenv("DOQ_PORT")
 	adminPort := os.Getenv("ADMIN_PORT")
 
 	dohCert := os.Getenv("DOH_CERT")
 	dohKey := os.Getenv("DOH_KEY")
+	dotCert := os.Getenv("DOT_CERT")
+	dotKey := os.Getenv("DOT_KEY")
 	adminHashedPassword := os.Getenv("ADMIN_HASHED_PASSWORD")
 	upstreamDNS := os.Getenv("UPSTREAM_DNS")
 	databaseFile := os.Getenv("DATABASE_FILE")
@@ -32,51 +56,338 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer store.Close()
+
+	access, adminACL, err := loadACL()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var views *view.Set
+	if path := os.Getenv("VIEWS_FILE"); path != "" {
+		if views, err = view.Load(path); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var blocking *blocklist.Filter
+	if path := os.Getenv("BLOCKING_FILE"); path != "" {
+		cfg, err := blocklist.LoadConfig(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if blocking, err = blocklist.New(cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var local *hosts.Source
+	if files := hosts.Files(os.Getenv("HOSTS_FILES"), os.Getenv("LEASE_FILES")); len(files) > 0 {
+		local, err = hosts.New(files, os.Getenv("LOCAL_DOMAIN"), uint32(envInt("HOSTS_TTL", 60)))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	groups, err := group.Load(os.Getenv("GROUPS_FILE"), blocking)
+	if err != nil {
+		log.Fatal(err)
+	}
+	groups.SetHostResolver(clientHosts(store, views, local))
+
+	rewrites, err := rewrite.Load(os.Getenv("REWRITES_FILE"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var policy *rpz.Policy
+	if path := os.Getenv("RPZ_FILE"); path != "" {
+		cfg, err := rpz.LoadConfig(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if policy, err = rpz.New(cfg); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	up := upstream.NewUDPUpstream(upstreamDNS)
 	logger := &resolver.StdLogger{}
-	res := resolver.New(store, up, logger)
+	res := resolver.New(store, up, logger,
+		resolver.WithACL(access),
+		resolver.WithViews(views),
+		resolver.WithBlocking(blocking),
+		resolver.WithRPZ(policy),
+		resolver.WithGroups(groups),
+		resolver.WithRewrites(rewrites),
+		resolver.WithHosts(local),
+		resolver.WithIdentity(identity.New(identityConfig())),
+	)
 
 	udp := transport.NewUDPServer(udpPort, res)
+	if n, err := strconv.Atoi(os.Getenv("UDP_SOCKETS")); err == nil {
+		udp.SetSockets(n)
+	}
+	if n, err := strconv.Atoi(os.Getenv("UDP_WORKERS")); err == nil {
+		udp.SetWorkers(n)
+	}
+	var limiter *rrl.Limiter
+	if rate, err := strconv.Atoi(os.Getenv("RRL_RESPONSES_PER_SECOND")); err == nil && rate > 0 {
+		limiter = rrl.New(rrlConfig(rate))
+		udp.SetRateLimiter(limiter)
+	}
 	tcp := transport.NewTCPServer(tcpPort, res)
 	doh := transport.NewDoHServer(dohPort, res, dohCert, dohKey)
 
+	// DoT به صورت پیش‌فرض از گواهی DoH استفاده می‌کند
+	if dotCert == "" || dotKey == "" {
+		dotCert, dotKey = dohCert, dohKey
+	}
+	dot := transport.NewDoTServer(dotPort, res, dotCert, dotKey)
+	doq := transport.NewDoQServer(doqPort, res, dohCert, dohKey)
+
 	adminSrv := admin.New(store, adminHashedPassword)
+	adminSrv.SetViews(views.Names())
+	reportUnservedRecords(store, views)
+	adminSrv.SetGroups(groups)
+	adminSrv.SetRewrites(rewrites)
+	adminSrv.SetAutoPTR(strings.Split(os.Getenv("AUTO_PTR_ZONES"), ","))
 	mux := http.NewServeMux()
 	adminSrv.Register(mux)
+	adminSrv.AddStats("udp", func() any {
+		return map[string]uint64{"dropped": udp.Dropped()}
+	})
+	if limiter != nil {
+		adminSrv.AddStats("rrl", func() any { return limiter.Stats() })
+	}
+	if blocking != nil {
+		adminSrv.AddStats("blocking", func() any { return blocking.Stats() })
+	}
+	if local != nil {
+		adminSrv.AddStats("hosts", func() any { return local.Stats() })
+	}
+	if policy != nil {
+		adminSrv.AddStats("rpz", func() any { return policy.Stats() })
+	}
+
+	adminHTTP := &http.Server{Addr: adminPort, Handler: adminACL.Middleware(mux)}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cleanupDone := make(chan struct{})
 	go func() {
+		defer close(cleanupDone)
 		ticker := time.NewTicker(30 * time.Second)
 		defer ticker.Stop()
-		for range ticker.C {
-			if err := store.CleanupExpired(); err != nil {
-				log.Printf("cleanup error: %v", err)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := store.CleanupExpired(); err != nil {
+					log.Printf("cleanup error: %v", err)
+				}
 			}
 		}
 	}()
 
-	go func() {
-		if err := udp.ListenAndServe(); err != nil {
-			log.Fatalf("UDP error: %v", err)
-		}
-	}()
+	if blocking != nil {
+		go blocking.Run(ctx)
+	}
+	if policy != nil {
+		go policy.Run(ctx)
+	}
+	go groups.Run(ctx)
+	if local != nil {
+		go local.Run(ctx)
+	}
 
-	go func() {
-		if err := tcp.ListenAndServe(); err != nil {
-			log.Fatalf("TCP error: %v", err)
+	servers := []namedServer{
+		{"UDP", udp},
+		{"TCP", tcp},
+		{"DoH", doh},
+		{"Admin", adminHTTP},
+	}
+	if dotPort != "" {
+		servers = append(servers, namedServer{"DoT", dot})
+	}
+	if doqPort != "" {
+		servers = append(servers, namedServer{"DoQ", doq})
+	}
+
+	// خطای راه‌اندازی هر سرور به main برگردانده می‌شود
+	errCh := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			err := srv.ListenAndServe()
+			if err != nil && !errors.Is(err, transport.ErrServerClosed) && !errors.Is(err, http.ErrServerClosed) {
+				errCh <- fmt.Errorf("%s error: %w", srv.name, err)
+			}
+		}()
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		log.Println("shutting down")
+	case err := <-errCh:
+		log.Println(err)
+		exitCode = 1
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Printf("%s shutdown: %v", srv.name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	cancel()
+	<-cleanupDone
+
+	if err := store.Close(); err != nil {
+		log.Printf("close storage: %v", err)
+	}
+	os.Exit(exitCode)
+}
+
+// loadACL reads the client access lists. Unset lists keep the defaults
+// from acl.Default; the admin port is open to all unless ACL_ADMIN is set.
+func loadACL() (*acl.ACL, *acl.List, error) {
+	a := acl.Default()
+	var err error
+	if a.Query, err = acl.Parse(os.Getenv("ACL_QUERY"), a.Query); err != nil {
+		return nil, nil, err
+	}
+	if a.Recursion, err = acl.Parse(os.Getenv("ACL_RECURSION"), a.Recursion); err != nil {
+		return nil, nil, err
+	}
+	if a.Transfer, err = acl.Parse(os.Getenv("ACL_TRANSFER"), a.Transfer); err != nil {
+		return nil, nil, err
+	}
+	adminACL, err := acl.Parse(os.Getenv("ACL_ADMIN"), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, adminACL, nil
+}
+
+func rrlConfig(rate int) rrl.Config {
+	cfg := rrl.DefaultConfig()
+	cfg.ResponsesPerSecond = rate
+	cfg.NXDomainsPerSecond = envInt("RRL_NXDOMAINS_PER_SECOND", rate)
+	cfg.ErrorsPerSecond = envInt("RRL_ERRORS_PER_SECOND", rate)
+	cfg.Window = time.Duration(envInt("RRL_WINDOW", int(cfg.Window/time.Second))) * time.Second
+	cfg.Slip = envInt("RRL_SLIP", cfg.Slip)
+	cfg.Leak = envInt("RRL_LEAK", cfg.Leak)
+	cfg.IPv4PrefixLen = envInt("RRL_IPV4_PREFIX", cfg.IPv4PrefixLen)
+	cfg.IPv6PrefixLen = envInt("RRL_IPV6_PREFIX", cfg.IPv6PrefixLen)
+	cfg.LogOnly = os.Getenv("RRL_LOG_ONLY") == "true"
+	return cfg
+}
+
+// clientHosts resolves the hostnames in group client lists from the local
+// A/AAAA records of every view and from the hosts and lease files. Answers
+// cached from upstream are not used, so a client cannot be matched through
+// a name somebody else controls.
+func clientHosts(store types.Storage, views *view.Set, local *hosts.Source) group.HostResolver {
+	return func(name string) []netip.Addr {
+		var addrs []netip.Addr
+		add := func(records []types.DNSRecord, localOnly bool) {
+			for _, rec := range records {
+				if localOnly && !rec.Local {
+					continue
+				}
+				if ip, err := netip.ParseAddr(rec.Value); err == nil {
+					addrs = append(addrs, ip)
+				}
+			}
 		}
-	}()
+		for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			q := types.DNSQuestion{Name: name + ".", Type: types.RecordType(t)}
+			for _, v := range views.Names() {
+				q.View = v
+				records, _ := store.Get(q)
+				add(records, true)
+			}
+			if local != nil {
+				records, _ := local.Lookup(q)
+				add(records, false)
+			}
+		}
+		return addrs
+	}
+}
 
-	go func() {
-		if err := doh.ListenAndServe(); err != nil {
-			log.Fatalf("DoH error: %v", err)
+// reportUnservedRecords logs local records stored in a view that is not
+// configured (such as the default view once views are set up). They stay
+// in the database and can be edited or deleted in the admin UI, but no
+// client is answered from them.
+func reportUnservedRecords(store types.Storage, views *view.Set) {
+	served := map[string]bool{}
+	for _, name := range views.Names() {
+		served[name] = true
+	}
+	unserved := map[string]int{}
+	for _, rec := range store.List() {
+		if rec.Local && !served[rec.View] {
+			unserved[rec.View]++
 		}
-	}()
+	}
+	for name, n := range unserved {
+		log.Printf("warning: %d records in view %q are not served by any configured view", n, name)
+	}
+}
 
-	go func() {
-		log.Fatal(http.ListenAndServe(adminPort, mux))
-	}()
+// identityConfig reads the CHAOS answers and the NSID. SERVER_ID names
+// this instance for hostname.bind, id.server and NSID; each can be set on
+// its own, and "none" turns it off.
+func identityConfig() identity.Config {
+	cfg := identity.DefaultConfig()
+	if id := os.Getenv("SERVER_ID"); id != "" {
+		cfg.HostnameBind, cfg.IDServer, cfg.NSID = id, id, id
+	}
+	cfg.VersionBind = envText("CHAOS_VERSION_BIND", cfg.VersionBind)
+	cfg.VersionServer = envText("CHAOS_VERSION_SERVER", cfg.VersionServer)
+	cfg.HostnameBind = envText("CHAOS_HOSTNAME_BIND", cfg.HostnameBind)
+	cfg.IDServer = envText("CHAOS_ID_SERVER", cfg.IDServer)
+	cfg.NSID = envText("NSID", cfg.NSID)
+	return cfg
+}
+
+// envText returns the variable's value, def when it is not set, or "" when
+// it is "none".
+func envText(name, def string) string {
+	switch v := os.Getenv(name); v {
+	case "":
+		return def
+	case "none":
+		return ""
+	default:
+		return v
+	}
+}
+
+func envInt(name string, def int) int {
+	if n, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return n
+	}
+	return def
+}
+
+type server interface {
+	ListenAndServe() error
+	Shutdown(ctx context.Context) error
+}
 
-	select {} // برنامه زنده بماند
+type namedServer struct {
+	name string
+	server
 }
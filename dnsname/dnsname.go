@@ -0,0 +1,101 @@
+// Package dnsname normalizes domain names: records are stored and looked
+// up under one canonical spelling of each name.
+package dnsname
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Canonical returns name lower-cased and absolute (with the trailing dot).
+// Labels with non-ASCII characters are converted to A-labels (punycode) by
+// the IDNA 2008 lookup rules, and every A-label must decode to a valid
+// U-label. Other labels are only lower-cased, so names like _sip._udp or
+// *.example.com stay valid.
+func Canonical(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("empty name")
+	}
+	if name == "." {
+		return name, nil
+	}
+	name = strings.TrimSuffix(name, ".")
+
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if label == "" {
+			return "", errors.New("empty label in " + strconv.Quote(name))
+		}
+		if isASCII(label) {
+			labels[i] = strings.ToLower(label)
+		} else {
+			// نگاشت UTS 46 (حروف کوچک، NFC) و سپس تبدیل به punycode
+			a, err := idna.Lookup.ToASCII(label)
+			if err != nil {
+				return "", errors.New("invalid label " + strconv.Quote(label) + ": " + err.Error())
+			}
+			labels[i] = a
+		}
+		if strings.HasPrefix(labels[i], acePrefix) {
+			if _, err := idna.Registration.ToUnicode(labels[i]); err != nil {
+				return "", errors.New("invalid label " + strconv.Quote(label) + ": " + err.Error())
+			}
+		}
+		if len(labels[i]) > 63 {
+			return "", errors.New("label longer than 63 bytes in " + strconv.Quote(name))
+		}
+	}
+
+	out := strings.Join(labels, ".") + "."
+	if len(out) > 254 {
+		return "", errors.New("name longer than 253 bytes")
+	}
+	return out, nil
+}
+
+// Fold is Canonical for lookups: a name that is not valid cannot match a
+// stored record, so it is only lower-cased and made absolute.
+func Fold(name string) string {
+	if c, err := Canonical(name); err == nil {
+		return c
+	}
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// Unicode returns name with its A-labels shown as U-labels, for display.
+// Labels that do not decode are left as they are.
+func Unicode(name string) string {
+	if !strings.Contains(strings.ToLower(name), acePrefix) {
+		return name
+	}
+	labels := strings.Split(name, ".")
+	for i, label := range labels {
+		if !strings.HasPrefix(strings.ToLower(label), acePrefix) {
+			continue
+		}
+		if u, err := idna.Lookup.ToUnicode(label); err == nil {
+			labels[i] = u
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// acePrefix starts every A-label.
+const acePrefix = "xn--"
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
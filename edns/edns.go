@@ -0,0 +1,112 @@
+// Package edns reads and adds EDNS(0) options (RFC 6891) in packed DNS
+// messages, for the options the server answers itself.
+package edns
+
+import (
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Option codes answered by the server.
+const (
+	OptNSID         = 3  // RFC 5001
+	OptTCPKeepalive = 11 // RFC 7828
+)
+
+// Has reports whether the OPT record of msg carries an option with code.
+func Has(msg []byte, code uint16) bool {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return false
+	}
+	if p.SkipAllQuestions() != nil || p.SkipAllAnswers() != nil || p.SkipAllAuthorities() != nil {
+		return false
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err != nil {
+			return false
+		}
+		if h.Type != dnsmessage.TypeOPT {
+			if err := p.SkipAdditional(); err != nil {
+				return false
+			}
+			continue
+		}
+		opt, err := p.OPTResource()
+		if err != nil {
+			return false
+		}
+		for _, o := range opt.Options {
+			if o.Code == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Add appends o to the OPT record of resp, adding an OPT record if it has
+// none. On any parse problem resp is returned unchanged. A signed response
+// must get its options before it is signed.
+func Add(resp []byte, o dnsmessage.Option) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return resp
+	}
+
+	found := false
+	for i, rr := range msg.Additionals {
+		if opt, ok := rr.Body.(*dnsmessage.OPTResource); ok {
+			opt.Options = append(opt.Options, o)
+			msg.Additionals[i].Body = opt
+			found = true
+		}
+	}
+	if !found {
+		var h dnsmessage.ResourceHeader
+		if err := h.SetEDNS0(4096, dnsmessage.RCodeSuccess, false); err != nil {
+			return resp
+		}
+		msg.Additionals = append(msg.Additionals, dnsmessage.Resource{
+			Header: h,
+			Body:   &dnsmessage.OPTResource{Options: []dnsmessage.Option{o}},
+		})
+	}
+
+	out, err := msg.Pack()
+	if err != nil {
+		return resp
+	}
+	return out
+}
+
+// WantsKeepalive reports whether the query carries an edns-tcp-keepalive
+// option, which is the only case in which the server may send one back.
+func WantsKeepalive(req []byte) bool {
+	return Has(req, OptTCPKeepalive)
+}
+
+// WithKeepalive adds an edns-tcp-keepalive option advertising timeout to
+// resp when the client asked for it.
+func WithKeepalive(req, resp []byte, timeout time.Duration) []byte {
+	if timeout <= 0 || !WantsKeepalive(req) {
+		return resp
+	}
+	// مقدار بر حسب واحدهای ۱۰۰ میلی‌ثانیه
+	units := uint16(min(timeout/(100*time.Millisecond), 0xffff))
+	return Add(resp, dnsmessage.Option{
+		Code: OptTCPKeepalive,
+		Data: []byte{byte(units >> 8), byte(units)},
+	})
+}
+
+// WithNSID adds the NSID option carrying id to resp when the query asked
+// for it and id is set.
+func WithNSID(req, resp, id []byte) []byte {
+	if len(id) == 0 || !Has(req, OptNSID) {
+		return resp
+	}
+	return Add(resp, dnsmessage.Option{Code: OptNSID, Data: id})
+}
@@ -0,0 +1,54 @@
+package edns
+
+import "golang.org/x/net/dns/dnsmessage"
+
+// Fit makes resp no larger than limit: first the additional section is
+// left out (except OPT), and if that is not enough the response is
+// truncated (RFC 2181 9). A signed response must be fitted before it is
+// signed.
+func Fit(resp []byte, limit int) ([]byte, error) {
+	if len(resp) <= limit {
+		return resp, nil
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		return nil, err
+	}
+	var opt []dnsmessage.Resource
+	for _, rr := range msg.Additionals {
+		if rr.Header.Type == dnsmessage.TypeOPT {
+			opt = append(opt, rr)
+		}
+	}
+	msg.Additionals = opt
+	out, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if len(out) <= limit {
+		return out, nil
+	}
+	return Truncate(resp)
+}
+
+// Truncate strips every record section from resp and sets TC, telling a
+// legitimate client to retry over TCP.
+func Truncate(resp []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return nil, err
+	}
+	qs, err := p.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+
+	h.Truncated = true
+	h.Authoritative = false
+	msg := dnsmessage.Message{
+		Header:    h,
+		Questions: qs,
+	}
+	return msg.Pack()
+}
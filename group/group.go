@@ -0,0 +1,419 @@
+// Package group assigns clients to filtering groups. Each group selects
+// the block lists applied to its clients and can block more during
+// scheduled hours.
+package group
+
+import (
+	"context"
+	"dns-server/blocklist"
+	"dns-server/dnsname"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Schedule struct {
+	Days       []string `json:"days,omitempty"` // mon..sun، weekdays یا weekend؛ خالی یعنی همه روزها
+	From       string   `json:"from"`           // "09:00"
+	To         string   `json:"to"`             // "17:00"؛ کوچکتر از From یعنی تا فردا
+	Lists      []string `json:"lists,omitempty"`
+	Categories []string `json:"categories,omitempty"`
+}
+
+type Group struct {
+	Name    string   `json:"name"`
+	Clients []string `json:"clients"` // IP، CIDR، MAC یا نام میزبان
+
+	// Lists selects the block lists for the group; empty means all lists.
+	// Unfiltered turns off every list outside the schedules.
+	Lists             []string   `json:"lists,omitempty"`
+	Unfiltered        bool       `json:"unfiltered,omitempty"`
+	AllowedCategories []string   `json:"allowed_categories,omitempty"`
+	Schedules         []Schedule `json:"schedules,omitempty"`
+}
+
+// compiled is a group with its clients and schedules parsed.
+type compiled struct {
+	Group
+	prefixes []netip.Prefix
+	macs     map[string]bool
+	hosts    []string
+	windows  []window
+}
+
+type window struct {
+	days     [7]bool
+	from, to int // دقیقه از ابتدای روز
+	Schedule
+}
+
+// HostResolver returns the addresses of a client hostname.
+type HostResolver func(name string) []netip.Addr
+
+type Set struct {
+	path   string
+	filter *blocklist.Filter
+
+	mu     sync.RWMutex
+	groups []*compiled
+	hosts  map[netip.Addr]string // آدرس -> نام میزبان
+	arp    map[netip.Addr]string // آدرس -> MAC
+
+	resolve HostResolver
+}
+
+// Load reads the groups from path. A missing file gives an empty set that
+// is created on the first change. With an empty path groups are kept in
+// memory only. List and category names are checked against filter when it
+// is set.
+func Load(path string, filter *blocklist.Filter) (*Set, error) {
+	s := &Set{path: path, filter: filter}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []Group
+	if err := json.Unmarshal(data, &groups); err != nil {
+		return nil, fmt.Errorf("group: %s: %w", path, err)
+	}
+	for _, g := range groups {
+		c, err := compile(g, filter)
+		if err != nil {
+			return nil, err
+		}
+		if s.index(g.Name) >= 0 {
+			return nil, fmt.Errorf("group: duplicate group %q", g.Name)
+		}
+		s.groups = append(s.groups, c)
+	}
+	return s, nil
+}
+
+// SetHostResolver sets how hostnames in client lists are resolved.
+func (s *Set) SetHostResolver(fn HostResolver) {
+	s.resolve = fn
+}
+
+// Run refreshes the neighbour table and client hostnames every 10s until
+// ctx is cancelled.
+func (s *Set) Run(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		s.refresh()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Set) refresh() {
+	s.mu.RLock()
+	var names []string
+	usesMAC := false
+	for _, g := range s.groups {
+		names = append(names, g.hosts...)
+		usesMAC = usesMAC || len(g.macs) > 0
+	}
+	s.mu.RUnlock()
+
+	hosts := make(map[netip.Addr]string)
+	if s.resolve != nil {
+		for _, name := range names {
+			for _, ip := range s.resolve(name) {
+				hosts[ip.Unmap()] = name
+			}
+		}
+	}
+
+	var arp map[netip.Addr]string
+	if usesMAC {
+		arp = readARP("/proc/net/arp")
+	}
+
+	s.mu.Lock()
+	s.hosts = hosts
+	s.arp = arp
+	s.mu.Unlock()
+}
+
+// readARP returns the IPv4 neighbours known to the kernel.
+func readARP(path string) map[netip.Addr]string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	out := make(map[netip.Addr]string)
+	// IP address  HW type  Flags  HW address  Mask  Device
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		f := strings.Fields(line)
+		if len(f) < 4 || f[2] == "0x0" {
+			continue
+		}
+		ip, err := netip.ParseAddr(f[0])
+		if err != nil {
+			continue
+		}
+		if mac, err := net.ParseMAC(f[3]); err == nil {
+			out[ip] = mac.String()
+		}
+	}
+	return out
+}
+
+// Mask returns the group of ip and the block lists of filter that apply
+// to it at now. Clients outside every group get all lists.
+func (s *Set) Mask(ip netip.Addr, filter *blocklist.Filter, now time.Time) (string, uint64) {
+	g := s.match(ip)
+	if g == nil {
+		return "", blocklist.All
+	}
+
+	var mask uint64
+	if !g.Unfiltered {
+		mask = blocklist.All
+		if len(g.Lists) > 0 {
+			mask = filter.Mask(g.Lists, nil)
+		}
+		mask &^= filter.Mask(nil, g.AllowedCategories)
+	}
+
+	for _, w := range g.windows {
+		if w.active(now) {
+			mask |= filter.Mask(w.Lists, w.Categories)
+		}
+	}
+	return g.Name, mask
+}
+
+// match returns the first group ip belongs to.
+func (s *Set) match(ip netip.Addr) *compiled {
+	ip = ip.Unmap()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	host, hasHost := s.hosts[ip]
+	mac, hasMAC := s.arp[ip]
+	for _, g := range s.groups {
+		for _, p := range g.prefixes {
+			if p.Contains(ip) {
+				return g
+			}
+		}
+		if hasMAC && g.macs[mac] {
+			return g
+		}
+		if hasHost {
+			for _, h := range g.hosts {
+				if h == host {
+					return g
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (w window) active(now time.Time) bool {
+	m := now.Hour()*60 + now.Minute()
+	day := int(now.Weekday())
+	if w.from <= w.to {
+		return w.days[day] && m >= w.from && m < w.to
+	}
+	// بازه‌ای که از نیمه شب می‌گذرد به روز شروع تعلق دارد
+	if m >= w.from {
+		return w.days[day]
+	}
+	return m < w.to && w.days[(day+6)%7]
+}
+
+// List returns the groups in match order.
+func (s *Set) List() []Group {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		out = append(out, g.Group)
+	}
+	return out
+}
+
+// Put adds g, or replaces the group with the same name in place, and
+// saves the set.
+func (s *Set) Put(g Group) error {
+	c, err := compile(g, s.filter)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if i := s.index(g.Name); i >= 0 {
+		s.groups[i] = c
+	} else {
+		s.groups = append(s.groups, c)
+	}
+	err = s.save()
+	s.mu.Unlock()
+
+	s.refresh()
+	return err
+}
+
+// Delete removes the named group and reports whether it existed.
+func (s *Set) Delete(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(name)
+	if i < 0 {
+		return false, nil
+	}
+	s.groups = append(s.groups[:i], s.groups[i+1:]...)
+	return true, s.save()
+}
+
+func (s *Set) index(name string) int {
+	for i, g := range s.groups {
+		if g.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// save writes the groups to the file; the caller holds s.mu.
+func (s *Set) save() error {
+	if s.path == "" {
+		return nil
+	}
+	groups := make([]Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g.Group)
+	}
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// نوشتن در فایل موقت و جایگزینی، تا فایل نیمه‌کاره باقی نماند
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".groups-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+var dayNames = map[string][]time.Weekday{
+	"sun":      {time.Sunday},
+	"mon":      {time.Monday},
+	"tue":      {time.Tuesday},
+	"wed":      {time.Wednesday},
+	"thu":      {time.Thursday},
+	"fri":      {time.Friday},
+	"sat":      {time.Saturday},
+	"weekdays": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend":  {time.Saturday, time.Sunday},
+}
+
+func compile(g Group, filter *blocklist.Filter) (*compiled, error) {
+	if strings.TrimSpace(g.Name) == "" {
+		return nil, errors.New("group: missing name")
+	}
+	// نام اشتباه در Lists ماسک صفر می‌دهد و فیلتر گروه را خاموش می‌کند
+	if filter != nil {
+		if err := filter.Check(g.Lists, g.AllowedCategories); err != nil {
+			return nil, fmt.Errorf("group %s: %w", g.Name, err)
+		}
+		for _, sc := range g.Schedules {
+			if err := filter.Check(sc.Lists, sc.Categories); err != nil {
+				return nil, fmt.Errorf("group %s: schedule: %w", g.Name, err)
+			}
+		}
+	}
+	c := &compiled{Group: g, macs: make(map[string]bool)}
+
+	for _, client := range g.Clients {
+		client = strings.TrimSpace(client)
+		if p, err := netip.ParsePrefix(client); err == nil {
+			c.prefixes = append(c.prefixes, p.Masked())
+			continue
+		}
+		if ip, err := netip.ParseAddr(client); err == nil {
+			c.prefixes = append(c.prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		if mac, err := net.ParseMAC(client); err == nil {
+			c.macs[mac.String()] = true
+			continue
+		}
+		host, err := dnsname.Canonical(client)
+		if err != nil || host == "." {
+			return nil, fmt.Errorf("group %s: invalid client %q", g.Name, client)
+		}
+		c.hosts = append(c.hosts, strings.TrimSuffix(host, "."))
+	}
+
+	for _, sc := range g.Schedules {
+		w := window{Schedule: sc}
+		var err error
+		if w.from, err = parseClock(sc.From); err != nil {
+			return nil, fmt.Errorf("group %s: %w", g.Name, err)
+		}
+		if w.to, err = parseClock(sc.To); err != nil {
+			return nil, fmt.Errorf("group %s: %w", g.Name, err)
+		}
+		if len(sc.Days) == 0 {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		for _, d := range sc.Days {
+			days, ok := dayNames[strings.ToLower(d)]
+			if !ok {
+				return nil, fmt.Errorf("group %s: invalid day %q", g.Name, d)
+			}
+			for _, wd := range days {
+				w.days[wd] = true
+			}
+		}
+		c.windows = append(c.windows, w)
+	}
+	return c, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight; "24:00" is the
+// end of the day.
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
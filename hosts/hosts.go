@@ -0,0 +1,284 @@
+// Package hosts answers queries from hosts files and DHCP lease files.
+// Files are polled for changes and every address gets a matching PTR
+// record.
+package hosts
+
+import (
+	"context"
+	"dns-server/dnsname"
+	"dns-server/types"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	FormatHosts   = "hosts"
+	FormatDnsmasq = "dnsmasq"
+	FormatISC     = "isc"
+)
+
+// pollInterval is how often files are checked for changes.
+const pollInterval = 5 * time.Second
+
+type File struct {
+	Path   string
+	Format string
+}
+
+// Files builds the file list from comma separated paths. Lease files may
+// be prefixed with "dnsmasq:" or "isc:"; without a prefix files whose name
+// contains "dhcpd" are read as ISC leases and others as dnsmasq leases.
+func Files(hostsFiles, leaseFiles string) []File {
+	var files []File
+	for _, p := range split(hostsFiles) {
+		files = append(files, File{Path: p, Format: FormatHosts})
+	}
+	for _, p := range split(leaseFiles) {
+		f := File{Path: p, Format: FormatDnsmasq}
+		if format, path, ok := strings.Cut(p, ":"); ok && (format == FormatDnsmasq || format == FormatISC) {
+			f = File{Path: path, Format: format}
+		} else if strings.Contains(p, "dhcpd") {
+			f.Format = FormatISC
+		}
+		files = append(files, f)
+	}
+	return files
+}
+
+func split(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+type file struct {
+	File
+	modTime time.Time
+	size    int64
+	entries []entry
+	err     error
+}
+
+type Source struct {
+	domain string // برای نام‌های بدون دامنه، مثلاً "lan."
+	ttl    uint32
+
+	pollMu sync.Mutex // از فایل‌ها محافظت می‌کند
+	files  []*file
+
+	mu    sync.RWMutex
+	addrs map[string][]netip.Addr // نام -> آدرس‌ها
+	ptrs  map[string][]string     // نام معکوس -> نام‌ها
+}
+
+// New creates a source for files. Names without a dot are also published
+// under domain when it is set.
+func New(files []File, domain string, ttl uint32) (*Source, error) {
+	s := &Source{ttl: ttl}
+	if domain != "" {
+		d, err := dnsname.Canonical(domain)
+		if err != nil {
+			return nil, fmt.Errorf("hosts: local domain: %w", err)
+		}
+		if d != "." {
+			s.domain = d
+		}
+	}
+	for _, f := range files {
+		switch f.Format {
+		case FormatHosts, FormatDnsmasq, FormatISC:
+		default:
+			return nil, fmt.Errorf("hosts: %s: unknown format %q", f.Path, f.Format)
+		}
+		s.files = append(s.files, &file{File: f})
+	}
+	s.poll()
+	return s, nil
+}
+
+// Run polls the files for changes until ctx is cancelled.
+func (s *Source) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.poll()
+		}
+	}
+}
+
+// poll reloads the files whose size or modification time changed and
+// rebuilds the index when any did. Lease files are also reloaded each
+// poll so that expired leases disappear.
+func (s *Source) poll() {
+	s.pollMu.Lock()
+	defer s.pollMu.Unlock()
+
+	changed := false
+	for _, f := range s.files {
+		st, err := os.Stat(f.Path)
+		if err != nil {
+			if f.err == nil {
+				log.Printf("hosts %s: %v", f.Path, err)
+				f.entries, changed = nil, true
+			}
+			f.err = err
+			continue
+		}
+		if f.err == nil && f.Format == FormatHosts && st.ModTime().Equal(f.modTime) && st.Size() == f.size {
+			continue
+		}
+
+		entries, err := f.load()
+		if err != nil {
+			log.Printf("hosts %s: %v (keeping previous entries)", f.Path, err)
+			f.err = err
+			continue
+		}
+		if f.Format == FormatHosts || !st.ModTime().Equal(f.modTime) || st.Size() != f.size {
+			log.Printf("hosts %s: %d entries", f.Path, len(entries))
+		}
+		f.modTime, f.size, f.err = st.ModTime(), st.Size(), nil
+		f.entries = entries
+		changed = true
+	}
+	if changed {
+		s.rebuild()
+	}
+}
+
+func (f *file) load() ([]entry, error) {
+	r, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	switch f.Format {
+	case FormatDnsmasq:
+		return parseDnsmasq(r, time.Now())
+	case FormatISC:
+		return parseISC(r, time.Now())
+	}
+	return parseHosts(r)
+}
+
+func (s *Source) rebuild() {
+	addrs := make(map[string][]netip.Addr)
+	ptrs := make(map[string][]string)
+
+	add := func(name string, addr netip.Addr) {
+		for _, a := range addrs[name] {
+			if a == addr {
+				return
+			}
+		}
+		addrs[name] = append(addrs[name], addr)
+	}
+
+	for _, f := range s.files {
+		for _, e := range f.entries {
+			var fqdns []string
+			for _, n := range e.names {
+				if !strings.Contains(n, ".") && s.domain != "" {
+					// نام کامل برای PTR ترجیح داده می‌شود
+					fqdns = append(fqdns, n+"."+s.domain)
+				}
+				fqdns = append(fqdns, n+".")
+			}
+			for _, n := range fqdns {
+				add(n, e.addr)
+			}
+
+			rev := types.ReverseName(e.addr)
+			if len(ptrs[rev]) == 0 {
+				ptrs[rev] = []string{fqdns[0]}
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.addrs = addrs
+	s.ptrs = ptrs
+	s.mu.Unlock()
+}
+
+// Lookup answers q when its name is in one of the files. A known name
+// without records of the queried type gives an empty answer.
+func (s *Source) Lookup(q types.DNSQuestion) ([]types.DNSRecord, bool) {
+	name := dnsname.Fold(q.Name)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if q.Type == types.RecordType(dnsmessage.TypePTR) {
+		if targets, ok := s.ptrs[name]; ok {
+			var out []types.DNSRecord
+			for _, t := range targets {
+				out = append(out, s.record(q, t))
+			}
+			return out, true
+		}
+	}
+
+	addrs, ok := s.addrs[name]
+	if !ok {
+		return nil, false
+	}
+	var out []types.DNSRecord
+	for _, a := range addrs {
+		if a.Is4() && q.Type == types.RecordType(dnsmessage.TypeA) ||
+			a.Is6() && q.Type == types.RecordType(dnsmessage.TypeAAAA) {
+			out = append(out, s.record(q, a.String()))
+		}
+	}
+	return out, true
+}
+
+func (s *Source) record(q types.DNSQuestion, value string) types.DNSRecord {
+	return types.DNSRecord{
+		Name:  q.Name,
+		Type:  q.Type,
+		View:  q.View,
+		Value: value,
+		TTL:   s.ttl,
+	}
+}
+
+type FileStats struct {
+	Path    string    `json:"path"`
+	Format  string    `json:"format"`
+	Entries int       `json:"entries"`
+	Updated time.Time `json:"updated"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// Stats reports the state of each file.
+func (s *Source) Stats() []FileStats {
+	s.pollMu.Lock()
+	defer s.pollMu.Unlock()
+
+	var out []FileStats
+	for _, f := range s.files {
+		fs := FileStats{Path: f.Path, Format: f.Format, Entries: len(f.entries), Updated: f.modTime}
+		if f.err != nil {
+			fs.Error = f.err.Error()
+		}
+		out = append(out, fs)
+	}
+	return out
+}
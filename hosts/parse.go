@@ -0,0 +1,182 @@
+package hosts
+
+import (
+	"bufio"
+	"dns-server/dnsname"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entry maps an address to the names it is known by; the first name is
+// the one used for PTR records.
+type entry struct {
+	addr  netip.Addr
+	names []string
+}
+
+// parseHosts reads a hosts(5) file.
+func parseHosts(r io.Reader) ([]entry, error) {
+	var out []entry
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		// آدرس‌های IPv6 با zone مانند fe80::1%eth0 کنار گذاشته می‌شوند
+		addr, err := netip.ParseAddr(f[0])
+		if err != nil || addr.Zone() != "" {
+			continue
+		}
+		e := entry{addr: addr.Unmap()}
+		for _, n := range f[1:] {
+			if n, ok := hostname(n); ok {
+				e.names = append(e.names, n)
+			}
+		}
+		if len(e.names) > 0 {
+			out = append(out, e)
+		}
+	}
+	return out, sc.Err()
+}
+
+// parseDnsmasq reads a dnsmasq lease file:
+//
+//	<expiry> <mac> <ipv4> <hostname> <client-id>
+//	duid <server-duid>
+//	<expiry> <iaid> <ipv6> <hostname> <client-duid>
+//
+// An expiry of 0 means the lease never expires.
+func parseDnsmasq(r io.Reader, now time.Time) ([]entry, error) {
+	var out []entry
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 4 || f[0] == "duid" {
+			continue
+		}
+		expiry, err := strconv.ParseInt(f[0], 10, 64)
+		if err != nil || expiry != 0 && time.Unix(expiry, 0).Before(now) {
+			continue
+		}
+		addr, err := netip.ParseAddr(f[2])
+		if err != nil {
+			continue
+		}
+		if n, ok := hostname(f[3]); ok {
+			out = append(out, entry{addr: addr.Unmap(), names: []string{n}})
+		}
+	}
+	return out, sc.Err()
+}
+
+// parseISC reads an ISC dhcpd.leases file. The file is append-only, so a
+// later declaration of a lease replaces earlier ones.
+func parseISC(r io.Reader, now time.Time) ([]entry, error) {
+	type lease struct {
+		name   string
+		active bool
+		ends   time.Time
+	}
+	var (
+		leases = make(map[netip.Addr]*lease)
+		order  []netip.Addr
+		cur    *lease
+		addr   netip.Addr
+	)
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if cur == nil {
+			f := strings.Fields(line)
+			if len(f) >= 3 && f[0] == "lease" && f[2] == "{" {
+				a, err := netip.ParseAddr(f[1])
+				if err != nil {
+					continue
+				}
+				addr = a.Unmap()
+				cur = &lease{active: true}
+			}
+			continue
+		}
+
+		if line == "}" {
+			if _, ok := leases[addr]; !ok {
+				order = append(order, addr)
+			}
+			leases[addr] = cur
+			cur = nil
+			continue
+		}
+
+		line = strings.TrimSuffix(line, ";")
+		f := strings.Fields(line)
+		switch {
+		case len(f) >= 3 && f[0] == "binding" && f[1] == "state":
+			cur.active = f[2] == "active"
+		case len(f) >= 2 && f[0] == "client-hostname":
+			cur.name = strings.Trim(strings.Join(f[1:], " "), `"`)
+		case len(f) >= 2 && f[0] == "ends":
+			cur.ends = parseISCTime(f[1:])
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []entry
+	for _, a := range order {
+		l := leases[a]
+		if !l.active || !l.ends.IsZero() && l.ends.Before(now) {
+			continue
+		}
+		if n, ok := hostname(l.name); ok {
+			out = append(out, entry{addr: a, names: []string{n}})
+		}
+	}
+	return out, nil
+}
+
+// parseISCTime parses the value of an "ends" statement: "never",
+// "<weekday> YYYY/MM/DD HH:MM:SS" in UTC or "epoch <seconds>". It returns
+// the zero time for leases that never end.
+func parseISCTime(f []string) time.Time {
+	switch {
+	case f[0] == "never":
+		return time.Time{}
+	case f[0] == "epoch" && len(f) >= 2:
+		if sec, err := strconv.ParseInt(f[1], 10, 64); err == nil {
+			return time.Unix(sec, 0)
+		}
+	case len(f) >= 3:
+		if t, err := time.Parse("2006/01/02 15:04:05", f[1]+" "+f[2]); err == nil {
+			return t
+		}
+	}
+	// زمان نامعتبر؛ lease منقضی در نظر گرفته می‌شود
+	return time.Unix(1, 0)
+}
+
+// hostname validates a host name from a file and returns it in canonical
+// form (see dnsname.Canonical), without a trailing dot. Wildcards are not
+// host names.
+func hostname(s string) (string, bool) {
+	name, err := dnsname.Canonical(s)
+	if err != nil || name == "." || strings.Contains(name, "*") {
+		return "", false
+	}
+	return strings.TrimSuffix(name, "."), true
+}
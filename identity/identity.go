@@ -0,0 +1,74 @@
+// Package identity tells one server instance from another: it holds the
+// answers to the CHAOS class TXT queries for version.bind, hostname.bind,
+// id.server and version.server (RFC 4892), and the identifier sent in the
+// EDNS NSID option (RFC 5001).
+package identity
+
+import "dns-server/dnsname"
+
+// Names answered in class CHAOS.
+const (
+	VersionBind   = "version.bind."
+	VersionServer = "version.server."
+	HostnameBind  = "hostname.bind."
+	IDServer      = "id.server."
+)
+
+type Config struct {
+	VersionBind   string // خالی = پاسخ داده نمی‌شود (REFUSED)
+	VersionServer string
+	HostnameBind  string
+	IDServer      string
+	NSID          string // خالی = گزینه‌ی NSID ارسال نمی‌شود
+}
+
+// DefaultConfig answers the version names with the server's name. The
+// identification names are refused and NSID is off, so that the machine's
+// host name is not given to any client unless it is configured.
+func DefaultConfig() Config {
+	return Config{
+		VersionBind:   "dns-server",
+		VersionServer: "dns-server",
+	}
+}
+
+type Identity struct {
+	txt  map[string]string
+	nsid []byte
+}
+
+func New(cfg Config) *Identity {
+	i := &Identity{txt: map[string]string{}}
+	for name, value := range map[string]string{
+		VersionBind:   cfg.VersionBind,
+		VersionServer: cfg.VersionServer,
+		HostnameBind:  cfg.HostnameBind,
+		IDServer:      cfg.IDServer,
+	} {
+		if value != "" {
+			i.txt[name] = value
+		}
+	}
+	if cfg.NSID != "" {
+		i.nsid = []byte(cfg.NSID)
+	}
+	return i
+}
+
+// TXT returns the text to answer a CHAOS query for name with, and false
+// for names that are not served or are disabled.
+func (i *Identity) TXT(name string) (string, bool) {
+	if i == nil {
+		return "", false
+	}
+	text, ok := i.txt[dnsname.Fold(name)]
+	return text, ok
+}
+
+// NSID returns the instance identifier for the NSID option, or nil.
+func (i *Identity) NSID() []byte {
+	if i == nil {
+		return nil
+	}
+	return i.nsid
+}
@@ -0,0 +1,344 @@
+// Package rdata converts record data between the presentation text kept in
+// types.DNSRecord.Value, structured values for the admin API and the DNS
+// wire format.
+package rdata
+
+import (
+	"dns-server/types"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// RData is the data of one resource record. Its fields are exported so it
+// can be used as a structured value in JSON.
+type RData interface {
+	// String returns the presentation format (RFC 1035 5.1).
+	String() string
+
+	parse(f []string) error
+	pack(b []byte) ([]byte, error)
+	unpack(b []byte) error
+}
+
+const (
+	TypeA      types.RecordType = 1
+	TypeNS     types.RecordType = 2
+	TypeCNAME  types.RecordType = 5
+	TypeSOA    types.RecordType = 6
+	TypePTR    types.RecordType = 12
+	TypeMX     types.RecordType = 15
+	TypeTXT    types.RecordType = 16
+	TypeAAAA   types.RecordType = 28
+	TypeSRV    types.RecordType = 33
+	TypeNAPTR  types.RecordType = 35
+	TypeDS     types.RecordType = 43
+	TypeSSHFP  types.RecordType = 44
+	TypeDNSKEY types.RecordType = 48
+	TypeTLSA   types.RecordType = 52
+	TypeSVCB   types.RecordType = 64
+	TypeHTTPS  types.RecordType = 65
+	TypeCAA    types.RecordType = 257
+)
+
+var registry = map[types.RecordType]struct {
+	name string
+	new  func() RData
+}{
+	TypeA:      {"A", func() RData { return &A{} }},
+	TypeNS:     {"NS", func() RData { return &NS{} }},
+	TypeCNAME:  {"CNAME", func() RData { return &CNAME{} }},
+	TypeSOA:    {"SOA", func() RData { return &SOA{} }},
+	TypePTR:    {"PTR", func() RData { return &PTR{} }},
+	TypeMX:     {"MX", func() RData { return &MX{} }},
+	TypeTXT:    {"TXT", func() RData { return &TXT{} }},
+	TypeAAAA:   {"AAAA", func() RData { return &AAAA{} }},
+	TypeSRV:    {"SRV", func() RData { return &SRV{} }},
+	TypeNAPTR:  {"NAPTR", func() RData { return &NAPTR{} }},
+	TypeDS:     {"DS", func() RData { return &DS{} }},
+	TypeSSHFP:  {"SSHFP", func() RData { return &SSHFP{} }},
+	TypeDNSKEY: {"DNSKEY", func() RData { return &DNSKEY{} }},
+	TypeTLSA:   {"TLSA", func() RData { return &TLSA{} }},
+	TypeSVCB:   {"SVCB", func() RData { return &SVCB{} }},
+	TypeHTTPS:  {"HTTPS", func() RData { return &SVCB{} }},
+	TypeCAA:    {"CAA", func() RData { return &CAA{} }},
+}
+
+// Types that are only known by number in presentation format.
+var otherNames = map[string]types.RecordType{
+	"ANY": 255, "AXFR": 252, "IXFR": 251, "OPT": 41, "RRSIG": 46,
+	"NSEC": 47, "NSEC3": 50, "NSEC3PARAM": 51, "CDS": 59, "CDNSKEY": 60,
+}
+
+// TypeName returns the mnemonic of t, or TYPEnnn (RFC 3597 5).
+func TypeName(t types.RecordType) string {
+	if r, ok := registry[t]; ok {
+		return r.name
+	}
+	for name, v := range otherNames {
+		if v == t {
+			return name
+		}
+	}
+	return "TYPE" + strconv.Itoa(int(t))
+}
+
+// ParseType parses a type mnemonic or TYPEnnn.
+func ParseType(s string) (types.RecordType, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	for t, r := range registry {
+		if r.name == s {
+			return t, nil
+		}
+	}
+	if t, ok := otherNames[s]; ok {
+		return t, nil
+	}
+	if n, ok := strings.CutPrefix(s, "TYPE"); ok {
+		if v, err := strconv.ParseUint(n, 10, 16); err == nil {
+			return types.RecordType(v), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown record type %q", s)
+}
+
+// New returns an empty value of type t, or a Generic value for types
+// without a structured form.
+func New(t types.RecordType) RData {
+	if r, ok := registry[t]; ok {
+		return r.new()
+	}
+	return &Generic{}
+}
+
+// Parse parses presentation text of type t. The RFC 3597 generic form
+// `\# <length> <hex>` is accepted for every type.
+func Parse(t types.RecordType, s string) (RData, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, `\#`) {
+		g := &Generic{}
+		if err := g.parse(strings.Fields(s)[1:]); err != nil {
+			return nil, fmt.Errorf("%s: %w", TypeName(t), err)
+		}
+		return FromWire(t, g.Data)
+	}
+
+	r := New(t)
+	if _, ok := r.(*Generic); ok {
+		return nil, fmt.Errorf("%s: only the generic \\# format is supported", TypeName(t))
+	}
+	if txt, ok := r.(*TXT); ok && !strings.Contains(s, `"`) {
+		// متن بدون نقل‌قول (مثل مقادیر قدیمی) یک رشته است، نه چند کلمه
+		txt.Strings = Chunks(s)
+		return txt, nil
+	}
+
+	f, err := fields(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", TypeName(t), err)
+	}
+	if err := r.parse(f); err != nil {
+		return nil, fmt.Errorf("%s: %w", TypeName(t), err)
+	}
+	return r, nil
+}
+
+// Legacy parses a value stored before record data had a canonical form.
+// TXT values then were the strings joined with spaces, without quotes.
+func Legacy(t types.RecordType, s string) (RData, error) {
+	if t == TypeTXT {
+		return &TXT{Strings: Chunks(s)}, nil
+	}
+	return Parse(t, s)
+}
+
+// Normalize fills in rec.RData from rec.Value, or the other way round
+// when only RData is set, and rewrites Value in canonical form.
+func Normalize(rec *types.DNSRecord) error {
+	var (
+		r   RData
+		err error
+	)
+	if len(rec.RData) > 0 {
+		r, err = FromWire(rec.Type, rec.RData)
+	} else {
+		r, err = Parse(rec.Type, rec.Value)
+	}
+	if err != nil {
+		return err
+	}
+	wire, err := r.pack(nil)
+	if err != nil {
+		return err
+	}
+	rec.Value, rec.RData = r.String(), wire
+	return nil
+}
+
+// Canonical parses s and formats it again, so that equal data always has
+// the same text.
+func Canonical(t types.RecordType, s string) (string, error) {
+	r, err := Parse(t, s)
+	if err != nil {
+		return "", err
+	}
+	return r.String(), nil
+}
+
+// Validate checks a structured value by formatting and parsing it again,
+// and returns its canonical text.
+func Validate(t types.RecordType, r RData) (string, error) {
+	return Canonical(t, r.String())
+}
+
+// Wire returns the RDATA of r in uncompressed wire format.
+func Wire(r RData) ([]byte, error) {
+	return r.pack(nil)
+}
+
+// FromWire decodes uncompressed RDATA of type t.
+func FromWire(t types.RecordType, b []byte) (RData, error) {
+	r := New(t)
+	if err := r.unpack(b); err != nil {
+		return nil, fmt.Errorf("%s: %w", TypeName(t), err)
+	}
+	return r, nil
+}
+
+// Generic is the RFC 3597 form used for types without a structured form.
+type Generic struct {
+	Data HexString `json:"data"`
+}
+
+func (g *Generic) String() string {
+	if len(g.Data) == 0 {
+		return `\# 0`
+	}
+	return `\# ` + strconv.Itoa(len(g.Data)) + " " + hex.EncodeToString(g.Data)
+}
+
+func (g *Generic) parse(f []string) error {
+	if len(f) == 0 {
+		return errors.New("missing length")
+	}
+	n, err := parseUint(f[0], 16)
+	if err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(strings.Join(f[1:], ""))
+	if err != nil {
+		return errors.New("invalid hex data")
+	}
+	if len(data) != int(n) {
+		return errors.New("length does not match data")
+	}
+	g.Data = data
+	return nil
+}
+
+func (g *Generic) pack(b []byte) ([]byte, error) {
+	return append(b, g.Data...), nil
+}
+
+func (g *Generic) unpack(b []byte) error {
+	g.Data = append(HexString(nil), b...)
+	return nil
+}
+
+// Body converts presentation text of type t into a resource body for
+// dnsmessage.
+func Body(t types.RecordType, value string) (dnsmessage.ResourceBody, error) {
+	r, err := Parse(t, value)
+	if err != nil {
+		return nil, err
+	}
+	return body(t, r)
+}
+
+// WireBody is Body for RDATA in wire format.
+func WireBody(t types.RecordType, b []byte) (dnsmessage.ResourceBody, error) {
+	r, err := FromWire(t, b)
+	if err != nil {
+		return nil, err
+	}
+	return body(t, r)
+}
+
+// body returns types dnsmessage can compress in their native form;
+// everything else is sent as opaque RDATA.
+func body(t types.RecordType, r RData) (dnsmessage.ResourceBody, error) {
+	switch v := r.(type) {
+	case *CNAME:
+		name, err := dnsmessage.NewName(v.Target)
+		return &dnsmessage.CNAMEResource{CNAME: name}, err
+	case *NS:
+		name, err := dnsmessage.NewName(v.Host)
+		return &dnsmessage.NSResource{NS: name}, err
+	case *PTR:
+		name, err := dnsmessage.NewName(v.Target)
+		return &dnsmessage.PTRResource{PTR: name}, err
+	case *MX:
+		name, err := dnsmessage.NewName(v.Exchange)
+		return &dnsmessage.MXResource{Pref: v.Preference, MX: name}, err
+	case *SOA:
+		ns, err := dnsmessage.NewName(v.MName)
+		if err != nil {
+			return nil, err
+		}
+		mbox, err := dnsmessage.NewName(v.RName)
+		return &dnsmessage.SOAResource{
+			NS: ns, MBox: mbox, Serial: v.Serial, Refresh: v.Refresh,
+			Retry: v.Retry, Expire: v.Expire, MinTTL: v.Minimum,
+		}, err
+	}
+
+	data, err := r.pack(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &dnsmessage.UnknownResource{Type: dnsmessage.Type(t), Data: data}, nil
+}
+
+// FromBody converts a parsed resource body.
+func FromBody(body dnsmessage.ResourceBody) (RData, error) {
+	var (
+		r   RData
+		err error
+	)
+	switch b := body.(type) {
+	case *dnsmessage.AResource:
+		r = &A{Address: addr4(b.A)}
+	case *dnsmessage.AAAAResource:
+		r = &AAAA{Address: addr16(b.AAAA)}
+	case *dnsmessage.CNAMEResource:
+		r = &CNAME{Target: b.CNAME.String()}
+	case *dnsmessage.NSResource:
+		r = &NS{Host: b.NS.String()}
+	case *dnsmessage.PTRResource:
+		r = &PTR{Target: b.PTR.String()}
+	case *dnsmessage.MXResource:
+		r = &MX{Preference: b.Pref, Exchange: b.MX.String()}
+	case *dnsmessage.TXTResource:
+		r = &TXT{Strings: append([]string(nil), b.TXT...)}
+	case *dnsmessage.SRVResource:
+		r = &SRV{Priority: b.Priority, Weight: b.Weight, Port: b.Port, Target: b.Target.String()}
+	case *dnsmessage.SOAResource:
+		r = &SOA{
+			MName: b.NS.String(), RName: b.MBox.String(), Serial: b.Serial,
+			Refresh: b.Refresh, Retry: b.Retry, Expire: b.Expire, Minimum: b.MinTTL,
+		}
+	case *dnsmessage.SVCBResource:
+		r, err = fromSVCB(b)
+	case *dnsmessage.HTTPSResource:
+		r, err = fromSVCB(&b.SVCBResource)
+	case *dnsmessage.UnknownResource:
+		r, err = FromWire(types.RecordType(b.Type), b.Data)
+	default:
+		return nil, errors.New("unsupported resource body")
+	}
+	return r, err
+}
@@ -0,0 +1,388 @@
+package rdata
+
+import (
+	"dns-server/dnsname"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// SVCB is a service binding, also used for HTTPS (RFC 9460). Params maps
+// key names to their presentation values; keys without a value, like
+// no-default-alpn, map to "".
+type SVCB struct {
+	Priority uint16            `json:"priority"`
+	Target   string            `json:"target"`
+	Params   map[string]string `json:"params,omitempty"`
+}
+
+var svcKeys = []string{
+	"mandatory", "alpn", "no-default-alpn", "port", "ipv4hint",
+	"ech", "ipv6hint", "dohpath", "ohttp", "tls-supported-groups",
+}
+
+func svcKeyName(k uint16) string {
+	if int(k) < len(svcKeys) {
+		return svcKeys[k]
+	}
+	return "key" + strconv.Itoa(int(k))
+}
+
+func parseSvcKey(s string) (uint16, error) {
+	s = strings.ToLower(s)
+	if i := slices.Index(svcKeys, s); i >= 0 {
+		return uint16(i), nil
+	}
+	if n, ok := strings.CutPrefix(s, "key"); ok && n != "" {
+		if v, err := strconv.ParseUint(n, 10, 16); err == nil && v != 65535 {
+			return uint16(v), nil
+		}
+	}
+	return 0, errors.New("unknown SvcParamKey " + strconv.Quote(s))
+}
+
+// keys returns the parameter keys in wire order.
+func (r *SVCB) keys() ([]uint16, error) {
+	keys := make([]uint16, 0, len(r.Params))
+	for name := range r.Params {
+		k, err := parseSvcKey(name)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(keys, k) {
+			return nil, errors.New("duplicate SvcParamKey " + svcKeyName(k))
+		}
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
+
+func (r *SVCB) String() string {
+	s := strconv.Itoa(int(r.Priority)) + " " + r.Target
+	keys, err := r.keys()
+	if err != nil {
+		return s
+	}
+	for _, k := range keys {
+		v := r.Params[paramName(r.Params, k)]
+		s += " " + svcKeyName(k)
+		if v == "" {
+			continue
+		}
+		if needsQuote(v) {
+			v = quote(v)
+		}
+		s += "=" + v
+	}
+	return s
+}
+
+// paramName finds the map key used for k, which may not be canonical in
+// values decoded from JSON.
+func paramName(params map[string]string, k uint16) string {
+	for name := range params {
+		if n, err := parseSvcKey(name); err == nil && n == k {
+			return name
+		}
+	}
+	return ""
+}
+
+func needsQuote(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c <= ' ' || c > '~' || c == '"' || c == '\\' || c == ';' {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *SVCB) parse(f []string) error {
+	if len(f) < 2 {
+		return errors.New("expected priority and target")
+	}
+	prio, err := parseUint(f[0], 16)
+	if err != nil {
+		return err
+	}
+	target, err := dnsname.Canonical(f[1])
+	if err != nil {
+		return err
+	}
+	params := map[string]string{}
+	for _, p := range f[2:] {
+		name, value, _ := strings.Cut(p, "=")
+		k, err := parseSvcKey(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := params[svcKeyName(k)]; ok {
+			return errors.New("duplicate SvcParamKey " + svcKeyName(k))
+		}
+		wire, err := packParam(k, value)
+		if err != nil {
+			return err
+		}
+		params[svcKeyName(k)], _ = unpackParam(k, wire)
+	}
+	if prio == 0 && len(params) > 0 {
+		return errors.New("AliasMode (priority 0) must not have parameters")
+	}
+	if m, ok := params["mandatory"]; ok {
+		for _, name := range strings.Split(m, ",") {
+			if _, ok := params[name]; !ok {
+				return errors.New("mandatory key " + name + " is missing")
+			}
+		}
+	}
+	r.Priority, r.Target, r.Params = uint16(prio), target, nil
+	if len(params) > 0 {
+		r.Params = params
+	}
+	return nil
+}
+
+// packParam converts a presentation value into its wire form.
+func packParam(k uint16, v string) ([]byte, error) {
+	name := svcKeyName(k)
+	list := func() []string {
+		if v == "" {
+			return nil
+		}
+		return strings.Split(v, ",")
+	}
+	switch k {
+	case 0:
+		var keys []uint16
+		for _, s := range list() {
+			mk, err := parseSvcKey(s)
+			if err != nil {
+				return nil, err
+			}
+			if mk == 0 || slices.Contains(keys, mk) {
+				return nil, errors.New("invalid mandatory key " + s)
+			}
+			keys = append(keys, mk)
+		}
+		if len(keys) == 0 {
+			return nil, errors.New("mandatory needs a value")
+		}
+		slices.Sort(keys)
+		var b []byte
+		for _, mk := range keys {
+			b = binary.BigEndian.AppendUint16(b, mk)
+		}
+		return b, nil
+	case 1:
+		var b []byte
+		for _, id := range list() {
+			if id == "" || len(id) > 255 {
+				return nil, errors.New("invalid alpn id")
+			}
+			b = append(b, byte(len(id)))
+			b = append(b, id...)
+		}
+		if len(b) == 0 {
+			return nil, errors.New("alpn needs a value")
+		}
+		return b, nil
+	case 2, 8:
+		if v != "" {
+			return nil, errors.New(name + " takes no value")
+		}
+		return nil, nil
+	case 3:
+		port, err := parseUint(v, 16)
+		if err != nil {
+			return nil, err
+		}
+		return binary.BigEndian.AppendUint16(nil, uint16(port)), nil
+	case 4, 6:
+		var b []byte
+		for _, s := range list() {
+			ip, err := netip.ParseAddr(s)
+			if err != nil || ip.Is4() != (k == 4) || ip.Zone() != "" {
+				return nil, errors.New("invalid " + name + " address " + strconv.Quote(s))
+			}
+			b = append(b, ip.AsSlice()...)
+		}
+		if len(b) == 0 {
+			return nil, errors.New(name + " needs a value")
+		}
+		return b, nil
+	case 5:
+		b, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(b) == 0 {
+			return nil, errors.New("invalid ech value")
+		}
+		return b, nil
+	case 7:
+		if v == "" {
+			return nil, errors.New("dohpath needs a value")
+		}
+		return []byte(v), nil
+	case 9:
+		var b []byte
+		for _, s := range list() {
+			g, err := parseUint(s, 16)
+			if err != nil {
+				return nil, err
+			}
+			b = binary.BigEndian.AppendUint16(b, uint16(g))
+		}
+		if len(b) == 0 {
+			return nil, errors.New(name + " needs a value")
+		}
+		return b, nil
+	}
+	return []byte(v), nil
+}
+
+// unpackParam converts a wire value into presentation form.
+func unpackParam(k uint16, b []byte) (string, error) {
+	name := svcKeyName(k)
+	bad := errors.New("invalid " + name + " value")
+	var out []string
+	switch k {
+	case 0, 9:
+		if len(b) == 0 || len(b)%2 != 0 {
+			return "", bad
+		}
+		for ; len(b) > 0; b = b[2:] {
+			n := binary.BigEndian.Uint16(b)
+			if k == 0 {
+				out = append(out, svcKeyName(n))
+			} else {
+				out = append(out, strconv.Itoa(int(n)))
+			}
+		}
+	case 1:
+		for len(b) > 0 {
+			var (
+				id  string
+				err error
+			)
+			if id, b, err = readString(b); err != nil || id == "" {
+				return "", bad
+			}
+			out = append(out, id)
+		}
+		if len(out) == 0 {
+			return "", bad
+		}
+	case 2, 8:
+		if len(b) != 0 {
+			return "", bad
+		}
+	case 3:
+		if len(b) != 2 {
+			return "", bad
+		}
+		return strconv.Itoa(int(binary.BigEndian.Uint16(b))), nil
+	case 4, 6:
+		size := 4
+		if k == 6 {
+			size = 16
+		}
+		if len(b) == 0 || len(b)%size != 0 {
+			return "", bad
+		}
+		for ; len(b) > 0; b = b[size:] {
+			ip, _ := netip.AddrFromSlice(b[:size])
+			out = append(out, ip.String())
+		}
+	case 5:
+		if len(b) == 0 {
+			return "", bad
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	default:
+		return string(b), nil
+	}
+	return strings.Join(out, ","), nil
+}
+
+func (r *SVCB) pack(b []byte) ([]byte, error) {
+	keys, err := r.keys()
+	if err != nil {
+		return nil, err
+	}
+	b = binary.BigEndian.AppendUint16(b, r.Priority)
+	if b, err = appendName(b, r.Target); err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		v, err := packParam(k, r.Params[paramName(r.Params, k)])
+		if err != nil {
+			return nil, err
+		}
+		if len(v) > 65535 {
+			return nil, errors.New("SvcParam value too long")
+		}
+		b = binary.BigEndian.AppendUint16(b, k)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(v)))
+		b = append(b, v...)
+	}
+	return b, nil
+}
+
+func (r *SVCB) unpack(b []byte) error {
+	var err error
+	if r.Priority, b, err = readUint16(b); err != nil {
+		return err
+	}
+	if r.Target, b, err = readName(b); err != nil {
+		return err
+	}
+	r.Params = nil
+	last := -1
+	for len(b) > 0 {
+		var k, n uint16
+		if k, b, err = readUint16(b); err != nil {
+			return err
+		}
+		if n, b, err = readUint16(b); err != nil {
+			return err
+		}
+		if int(k) <= last {
+			return errors.New("SvcParamKeys out of order")
+		}
+		if len(b) < int(n) {
+			return errShort
+		}
+		if err := r.set(k, b[:n]); err != nil {
+			return err
+		}
+		last, b = int(k), b[n:]
+	}
+	return nil
+}
+
+func (r *SVCB) set(k uint16, v []byte) error {
+	s, err := unpackParam(k, v)
+	if err != nil {
+		return err
+	}
+	if r.Params == nil {
+		r.Params = map[string]string{}
+	}
+	r.Params[svcKeyName(k)] = s
+	return nil
+}
+
+func fromSVCB(b *dnsmessage.SVCBResource) (RData, error) {
+	r := &SVCB{Priority: b.Priority, Target: b.Target.String()}
+	for _, p := range b.Params {
+		if err := r.set(uint16(p.Key), p.Value); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
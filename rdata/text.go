@@ -0,0 +1,115 @@
+package rdata
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// fields splits presentation text into fields. Quoted strings keep their
+// spaces; escapes (\X and \DDD, RFC 1035 5.1) are decoded in both quoted
+// and unquoted fields.
+func fields(s string) ([]string, error) {
+	var (
+		out    []string
+		cur    []byte
+		inWord bool
+		quoted bool
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			if i+1 >= len(s) {
+				return nil, errors.New("trailing backslash")
+			}
+			if i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]) {
+				n, _ := strconv.Atoi(s[i+1 : i+4])
+				if n > 255 {
+					return nil, errors.New("invalid escape \\" + s[i+1:i+4])
+				}
+				cur = append(cur, byte(n))
+				i += 3
+			} else {
+				cur = append(cur, s[i+1])
+				i++
+			}
+			inWord = true
+		case c == '"':
+			if quoted {
+				out = append(out, string(cur))
+				cur, inWord, quoted = nil, false, false
+				continue
+			}
+			if inWord {
+				// key="value" در SVCB
+				if cur[len(cur)-1] != '=' {
+					return nil, errors.New("quote inside field")
+				}
+				quoted = true
+				continue
+			}
+			quoted, inWord = true, true
+		case (c == ' ' || c == '\t') && !quoted:
+			if inWord {
+				out = append(out, string(cur))
+				cur, inWord = nil, false
+			}
+		default:
+			cur = append(cur, c)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quoted string")
+	}
+	if inWord {
+		out = append(out, string(cur))
+	}
+	return out, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// quote formats a character-string for presentation.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			b.WriteByte('\\')
+			b.WriteString(pad3(int(c)))
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func pad3(n int) string {
+	s := strconv.Itoa(n)
+	return strings.Repeat("0", 3-len(s)) + s
+}
+
+func parseUint(s string, bits int) (uint64, error) {
+	n, err := strconv.ParseUint(s, 10, bits)
+	if err != nil {
+		return 0, errors.New("invalid number " + strconv.Quote(s))
+	}
+	return n, nil
+}
+
+func wantFields(f []string, n int) error {
+	if len(f) != n {
+		return errors.New("expected " + strconv.Itoa(n) + " fields, got " + strconv.Itoa(len(f)))
+	}
+	return nil
+}
@@ -0,0 +1,697 @@
+package rdata
+
+import (
+	"dns-server/dnsname"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+func addr4(a [4]byte) string   { return netip.AddrFrom4(a).String() }
+func addr16(a [16]byte) string { return netip.AddrFrom16(a).String() }
+
+// A is an IPv4 address.
+type A struct {
+	Address string `json:"address"`
+}
+
+func (r *A) String() string { return r.Address }
+
+func (r *A) parse(f []string) error {
+	if err := wantFields(f, 1); err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(f[0])
+	if err != nil || !ip.Is4() {
+		return errors.New("invalid IPv4 address " + strconv.Quote(f[0]))
+	}
+	r.Address = ip.String()
+	return nil
+}
+
+func (r *A) pack(b []byte) ([]byte, error) {
+	ip, err := netip.ParseAddr(r.Address)
+	if err != nil || !ip.Is4() {
+		return nil, errors.New("invalid IPv4 address")
+	}
+	return append(b, ip.AsSlice()...), nil
+}
+
+func (r *A) unpack(b []byte) error {
+	if len(b) != 4 {
+		return errors.New("A rdata must be 4 bytes")
+	}
+	r.Address = addr4([4]byte(b))
+	return nil
+}
+
+// AAAA is an IPv6 address.
+type AAAA struct {
+	Address string `json:"address"`
+}
+
+func (r *AAAA) String() string { return r.Address }
+
+func (r *AAAA) parse(f []string) error {
+	if err := wantFields(f, 1); err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(f[0])
+	if err != nil || !ip.Is6() || ip.Zone() != "" {
+		return errors.New("invalid IPv6 address " + strconv.Quote(f[0]))
+	}
+	r.Address = ip.String()
+	return nil
+}
+
+func (r *AAAA) pack(b []byte) ([]byte, error) {
+	ip, err := netip.ParseAddr(r.Address)
+	if err != nil || !ip.Is6() {
+		return nil, errors.New("invalid IPv6 address")
+	}
+	a := ip.As16()
+	return append(b, a[:]...), nil
+}
+
+func (r *AAAA) unpack(b []byte) error {
+	if len(b) != 16 {
+		return errors.New("AAAA rdata must be 16 bytes")
+	}
+	r.Address = addr16([16]byte(b))
+	return nil
+}
+
+// oneName is the data of the types that carry a single domain name.
+type oneName struct{ name *string }
+
+func (n oneName) parse(f []string) error {
+	if err := wantFields(f, 1); err != nil {
+		return err
+	}
+	name, err := dnsname.Canonical(f[0])
+	*n.name = name
+	return err
+}
+
+func (n oneName) unpack(b []byte) error {
+	name, rest, err := readName(b)
+	if err != nil {
+		return err
+	}
+	*n.name = name
+	return done(rest)
+}
+
+// CNAME is an alias target.
+type CNAME struct {
+	Target string `json:"target"`
+}
+
+func (r *CNAME) String() string                { return r.Target }
+func (r *CNAME) parse(f []string) error        { return oneName{&r.Target}.parse(f) }
+func (r *CNAME) pack(b []byte) ([]byte, error) { return appendName(b, r.Target) }
+func (r *CNAME) unpack(b []byte) error         { return oneName{&r.Target}.unpack(b) }
+
+// PTR is a pointer, usually from a reverse name.
+type PTR struct {
+	Target string `json:"target"`
+}
+
+func (r *PTR) String() string                { return r.Target }
+func (r *PTR) parse(f []string) error        { return oneName{&r.Target}.parse(f) }
+func (r *PTR) pack(b []byte) ([]byte, error) { return appendName(b, r.Target) }
+func (r *PTR) unpack(b []byte) error         { return oneName{&r.Target}.unpack(b) }
+
+// NS is a name server.
+type NS struct {
+	Host string `json:"host"`
+}
+
+func (r *NS) String() string                { return r.Host }
+func (r *NS) parse(f []string) error        { return oneName{&r.Host}.parse(f) }
+func (r *NS) pack(b []byte) ([]byte, error) { return appendName(b, r.Host) }
+func (r *NS) unpack(b []byte) error         { return oneName{&r.Host}.unpack(b) }
+
+// MX is a mail exchanger.
+type MX struct {
+	Preference uint16 `json:"preference"`
+	Exchange   string `json:"exchange"`
+}
+
+func (r *MX) String() string {
+	return strconv.Itoa(int(r.Preference)) + " " + r.Exchange
+}
+
+func (r *MX) parse(f []string) error {
+	if err := wantFields(f, 2); err != nil {
+		return err
+	}
+	pref, err := parseUint(f[0], 16)
+	if err != nil {
+		return err
+	}
+	r.Preference = uint16(pref)
+	r.Exchange, err = dnsname.Canonical(f[1])
+	return err
+}
+
+func (r *MX) pack(b []byte) ([]byte, error) {
+	b = binary.BigEndian.AppendUint16(b, r.Preference)
+	return appendName(b, r.Exchange)
+}
+
+func (r *MX) unpack(b []byte) error {
+	var err error
+	if r.Preference, b, err = readUint16(b); err != nil {
+		return err
+	}
+	if r.Exchange, b, err = readName(b); err != nil {
+		return err
+	}
+	return done(b)
+}
+
+// TXT is one or more character-strings. Strings longer than 255 bytes are
+// split when parsed, as a DKIM key has to be.
+type TXT struct {
+	Strings []string `json:"strings"`
+}
+
+func (r *TXT) String() string {
+	q := make([]string, len(r.Strings))
+	for i, s := range r.Strings {
+		q[i] = quote(s)
+	}
+	return strings.Join(q, " ")
+}
+
+func (r *TXT) parse(f []string) error {
+	if len(f) == 0 {
+		return errors.New("expected at least one string")
+	}
+	r.Strings = nil
+	for _, s := range f {
+		r.Strings = append(r.Strings, Chunks(s)...)
+	}
+	return nil
+}
+
+// Chunks cuts s into the strings of at most 255 bytes a TXT record is
+// made of.
+func Chunks(s string) []string {
+	out := []string{}
+	for len(s) > 255 {
+		out = append(out, s[:255])
+		s = s[255:]
+	}
+	return append(out, s)
+}
+
+func (r *TXT) pack(b []byte) ([]byte, error) {
+	if len(r.Strings) == 0 {
+		return nil, errors.New("TXT needs at least one string")
+	}
+	var err error
+	for _, s := range r.Strings {
+		if b, err = appendString(b, s); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (r *TXT) unpack(b []byte) error {
+	if len(b) == 0 {
+		return errShort
+	}
+	r.Strings = nil
+	for len(b) > 0 {
+		var (
+			s   string
+			err error
+		)
+		if s, b, err = readString(b); err != nil {
+			return err
+		}
+		r.Strings = append(r.Strings, s)
+	}
+	return nil
+}
+
+// SRV locates a service (RFC 2782).
+type SRV struct {
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Port     uint16 `json:"port"`
+	Target   string `json:"target"`
+}
+
+func (r *SRV) String() string {
+	return strconv.Itoa(int(r.Priority)) + " " + strconv.Itoa(int(r.Weight)) + " " +
+		strconv.Itoa(int(r.Port)) + " " + r.Target
+}
+
+func (r *SRV) parse(f []string) error {
+	if err := wantFields(f, 4); err != nil {
+		return err
+	}
+	var n [3]uint64
+	for i := range n {
+		v, err := parseUint(f[i], 16)
+		if err != nil {
+			return err
+		}
+		n[i] = v
+	}
+	r.Priority, r.Weight, r.Port = uint16(n[0]), uint16(n[1]), uint16(n[2])
+	var err error
+	r.Target, err = dnsname.Canonical(f[3])
+	return err
+}
+
+func (r *SRV) pack(b []byte) ([]byte, error) {
+	b = binary.BigEndian.AppendUint16(b, r.Priority)
+	b = binary.BigEndian.AppendUint16(b, r.Weight)
+	b = binary.BigEndian.AppendUint16(b, r.Port)
+	return appendName(b, r.Target)
+}
+
+func (r *SRV) unpack(b []byte) error {
+	var err error
+	if r.Priority, b, err = readUint16(b); err != nil {
+		return err
+	}
+	if r.Weight, b, err = readUint16(b); err != nil {
+		return err
+	}
+	if r.Port, b, err = readUint16(b); err != nil {
+		return err
+	}
+	if r.Target, b, err = readName(b); err != nil {
+		return err
+	}
+	return done(b)
+}
+
+// SOA marks the start of a zone.
+type SOA struct {
+	MName   string `json:"mname"`
+	RName   string `json:"rname"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	Minimum uint32 `json:"minimum"`
+}
+
+func (r *SOA) String() string {
+	return r.MName + " " + r.RName + " " + strconv.FormatUint(uint64(r.Serial), 10) + " " +
+		strconv.FormatUint(uint64(r.Refresh), 10) + " " + strconv.FormatUint(uint64(r.Retry), 10) + " " +
+		strconv.FormatUint(uint64(r.Expire), 10) + " " + strconv.FormatUint(uint64(r.Minimum), 10)
+}
+
+func (r *SOA) parse(f []string) error {
+	if err := wantFields(f, 7); err != nil {
+		return err
+	}
+	var err error
+	if r.MName, err = dnsname.Canonical(f[0]); err != nil {
+		return err
+	}
+	if r.RName, err = dnsname.Canonical(f[1]); err != nil {
+		return err
+	}
+	for i, p := range []*uint32{&r.Serial, &r.Refresh, &r.Retry, &r.Expire, &r.Minimum} {
+		v, err := parseUint(f[2+i], 32)
+		if err != nil {
+			return err
+		}
+		*p = uint32(v)
+	}
+	return nil
+}
+
+func (r *SOA) pack(b []byte) ([]byte, error) {
+	b, err := appendName(b, r.MName)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = appendName(b, r.RName); err != nil {
+		return nil, err
+	}
+	for _, v := range []uint32{r.Serial, r.Refresh, r.Retry, r.Expire, r.Minimum} {
+		b = binary.BigEndian.AppendUint32(b, v)
+	}
+	return b, nil
+}
+
+func (r *SOA) unpack(b []byte) error {
+	var err error
+	if r.MName, b, err = readName(b); err != nil {
+		return err
+	}
+	if r.RName, b, err = readName(b); err != nil {
+		return err
+	}
+	for _, p := range []*uint32{&r.Serial, &r.Refresh, &r.Retry, &r.Expire, &r.Minimum} {
+		if *p, b, err = readUint32(b); err != nil {
+			return err
+		}
+	}
+	return done(b)
+}
+
+// CAA restricts which CAs may issue certificates (RFC 8659).
+type CAA struct {
+	Flags uint8  `json:"flags"`
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+func (r *CAA) String() string {
+	return strconv.Itoa(int(r.Flags)) + " " + r.Tag + " " + quote(r.Value)
+}
+
+func (r *CAA) parse(f []string) error {
+	if err := wantFields(f, 3); err != nil {
+		return err
+	}
+	flags, err := parseUint(f[0], 8)
+	if err != nil {
+		return err
+	}
+	if err := checkTag(f[1]); err != nil {
+		return err
+	}
+	r.Flags, r.Tag, r.Value = uint8(flags), strings.ToLower(f[1]), f[2]
+	return nil
+}
+
+func checkTag(tag string) error {
+	if tag == "" || len(tag) > 255 {
+		return errors.New("invalid CAA tag")
+	}
+	for i := 0; i < len(tag); i++ {
+		c := tag[i] | 0x20
+		if !(c >= 'a' && c <= 'z') && !isDigit(tag[i]) {
+			return errors.New("invalid CAA tag " + strconv.Quote(tag))
+		}
+	}
+	return nil
+}
+
+func (r *CAA) pack(b []byte) ([]byte, error) {
+	if err := checkTag(r.Tag); err != nil {
+		return nil, err
+	}
+	b = append(b, r.Flags, byte(len(r.Tag)))
+	b = append(b, r.Tag...)
+	return append(b, r.Value...), nil
+}
+
+func (r *CAA) unpack(b []byte) error {
+	var err error
+	if r.Flags, b, err = readUint8(b); err != nil {
+		return err
+	}
+	if r.Tag, b, err = readString(b); err != nil {
+		return err
+	}
+	if err := checkTag(r.Tag); err != nil {
+		return err
+	}
+	r.Value = string(b)
+	return nil
+}
+
+// NAPTR is a naming authority pointer (RFC 3403).
+type NAPTR struct {
+	Order       uint16 `json:"order"`
+	Preference  uint16 `json:"preference"`
+	Flags       string `json:"flags"`
+	Services    string `json:"services"`
+	Regexp      string `json:"regexp"`
+	Replacement string `json:"replacement"`
+}
+
+func (r *NAPTR) String() string {
+	return strconv.Itoa(int(r.Order)) + " " + strconv.Itoa(int(r.Preference)) + " " +
+		quote(r.Flags) + " " + quote(r.Services) + " " + quote(r.Regexp) + " " + r.Replacement
+}
+
+func (r *NAPTR) parse(f []string) error {
+	if err := wantFields(f, 6); err != nil {
+		return err
+	}
+	order, err := parseUint(f[0], 16)
+	if err != nil {
+		return err
+	}
+	pref, err := parseUint(f[1], 16)
+	if err != nil {
+		return err
+	}
+	for _, s := range f[2:5] {
+		if len(s) > 255 {
+			return errors.New("character-string longer than 255 bytes")
+		}
+	}
+	r.Order, r.Preference = uint16(order), uint16(pref)
+	r.Flags, r.Services, r.Regexp = f[2], f[3], f[4]
+	r.Replacement, err = dnsname.Canonical(f[5])
+	return err
+}
+
+func (r *NAPTR) pack(b []byte) ([]byte, error) {
+	b = binary.BigEndian.AppendUint16(b, r.Order)
+	b = binary.BigEndian.AppendUint16(b, r.Preference)
+	var err error
+	for _, s := range []string{r.Flags, r.Services, r.Regexp} {
+		if b, err = appendString(b, s); err != nil {
+			return nil, err
+		}
+	}
+	return appendName(b, r.Replacement)
+}
+
+func (r *NAPTR) unpack(b []byte) error {
+	var err error
+	if r.Order, b, err = readUint16(b); err != nil {
+		return err
+	}
+	if r.Preference, b, err = readUint16(b); err != nil {
+		return err
+	}
+	for _, p := range []*string{&r.Flags, &r.Services, &r.Regexp} {
+		if *p, b, err = readString(b); err != nil {
+			return err
+		}
+	}
+	if r.Replacement, b, err = readName(b); err != nil {
+		return err
+	}
+	return done(b)
+}
+
+// parseHex joins the remaining fields, since long digests are often split
+// over several.
+func parseHex(f []string) ([]byte, error) {
+	data, err := hex.DecodeString(strings.Join(f, ""))
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid hex data")
+	}
+	return data, nil
+}
+
+// parseNumbers parses the leading numeric fields of the DNSSEC style types.
+func parseNumbers(f []string, bits ...int) ([]uint64, error) {
+	if len(f) <= len(bits) {
+		return nil, errors.New("expected at least " + strconv.Itoa(len(bits)+1) + " fields")
+	}
+	out := make([]uint64, len(bits))
+	for i, n := range bits {
+		v, err := parseUint(f[i], n)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+// SSHFP is an SSH host key fingerprint (RFC 4255).
+type SSHFP struct {
+	Algorithm   uint8     `json:"algorithm"`
+	Type        uint8     `json:"fp_type"`
+	Fingerprint HexString `json:"fingerprint"`
+}
+
+func (r *SSHFP) String() string {
+	return strconv.Itoa(int(r.Algorithm)) + " " + strconv.Itoa(int(r.Type)) + " " + r.Fingerprint.String()
+}
+
+func (r *SSHFP) parse(f []string) error {
+	n, err := parseNumbers(f, 8, 8)
+	if err != nil {
+		return err
+	}
+	r.Algorithm, r.Type = uint8(n[0]), uint8(n[1])
+	r.Fingerprint, err = parseHex(f[2:])
+	return err
+}
+
+func (r *SSHFP) pack(b []byte) ([]byte, error) {
+	b = append(b, r.Algorithm, r.Type)
+	return append(b, r.Fingerprint...), nil
+}
+
+func (r *SSHFP) unpack(b []byte) error {
+	if len(b) < 3 {
+		return errShort
+	}
+	r.Algorithm, r.Type = b[0], b[1]
+	r.Fingerprint = append(HexString(nil), b[2:]...)
+	return nil
+}
+
+// TLSA associates a certificate with a service (RFC 6698).
+type TLSA struct {
+	Usage        uint8     `json:"usage"`
+	Selector     uint8     `json:"selector"`
+	MatchingType uint8     `json:"matching_type"`
+	Certificate  HexString `json:"certificate"`
+}
+
+func (r *TLSA) String() string {
+	return strconv.Itoa(int(r.Usage)) + " " + strconv.Itoa(int(r.Selector)) + " " +
+		strconv.Itoa(int(r.MatchingType)) + " " + r.Certificate.String()
+}
+
+func (r *TLSA) parse(f []string) error {
+	n, err := parseNumbers(f, 8, 8, 8)
+	if err != nil {
+		return err
+	}
+	r.Usage, r.Selector, r.MatchingType = uint8(n[0]), uint8(n[1]), uint8(n[2])
+	r.Certificate, err = parseHex(f[3:])
+	return err
+}
+
+func (r *TLSA) pack(b []byte) ([]byte, error) {
+	b = append(b, r.Usage, r.Selector, r.MatchingType)
+	return append(b, r.Certificate...), nil
+}
+
+func (r *TLSA) unpack(b []byte) error {
+	if len(b) < 4 {
+		return errShort
+	}
+	r.Usage, r.Selector, r.MatchingType = b[0], b[1], b[2]
+	r.Certificate = append(HexString(nil), b[3:]...)
+	return nil
+}
+
+// DS is a delegation signer (RFC 4034 5).
+type DS struct {
+	KeyTag     uint16    `json:"key_tag"`
+	Algorithm  uint8     `json:"algorithm"`
+	DigestType uint8     `json:"digest_type"`
+	Digest     HexString `json:"digest"`
+}
+
+func (r *DS) String() string {
+	return strconv.Itoa(int(r.KeyTag)) + " " + strconv.Itoa(int(r.Algorithm)) + " " +
+		strconv.Itoa(int(r.DigestType)) + " " + r.Digest.String()
+}
+
+func (r *DS) parse(f []string) error {
+	n, err := parseNumbers(f, 16, 8, 8)
+	if err != nil {
+		return err
+	}
+	r.KeyTag, r.Algorithm, r.DigestType = uint16(n[0]), uint8(n[1]), uint8(n[2])
+	r.Digest, err = parseHex(f[3:])
+	return err
+}
+
+func (r *DS) pack(b []byte) ([]byte, error) {
+	b = binary.BigEndian.AppendUint16(b, r.KeyTag)
+	b = append(b, r.Algorithm, r.DigestType)
+	return append(b, r.Digest...), nil
+}
+
+func (r *DS) unpack(b []byte) error {
+	if len(b) < 5 {
+		return errShort
+	}
+	r.KeyTag = binary.BigEndian.Uint16(b)
+	r.Algorithm, r.DigestType = b[2], b[3]
+	r.Digest = append(HexString(nil), b[4:]...)
+	return nil
+}
+
+// DNSKEY is a zone signing public key (RFC 4034 2).
+type DNSKEY struct {
+	Flags     uint16 `json:"flags"`
+	Protocol  uint8  `json:"protocol"`
+	Algorithm uint8  `json:"algorithm"`
+	PublicKey []byte `json:"public_key"`
+}
+
+func (r *DNSKEY) String() string {
+	return strconv.Itoa(int(r.Flags)) + " " + strconv.Itoa(int(r.Protocol)) + " " +
+		strconv.Itoa(int(r.Algorithm)) + " " + base64.StdEncoding.EncodeToString(r.PublicKey)
+}
+
+func (r *DNSKEY) parse(f []string) error {
+	n, err := parseNumbers(f, 16, 8, 8)
+	if err != nil {
+		return err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.Join(f[3:], ""))
+	if err != nil || len(key) == 0 {
+		return errors.New("invalid base64 public key")
+	}
+	r.Flags, r.Protocol, r.Algorithm, r.PublicKey = uint16(n[0]), uint8(n[1]), uint8(n[2]), key
+	return nil
+}
+
+func (r *DNSKEY) pack(b []byte) ([]byte, error) {
+	b = binary.BigEndian.AppendUint16(b, r.Flags)
+	b = append(b, r.Protocol, r.Algorithm)
+	return append(b, r.PublicKey...), nil
+}
+
+func (r *DNSKEY) unpack(b []byte) error {
+	if len(b) < 5 {
+		return errShort
+	}
+	r.Flags = binary.BigEndian.Uint16(b)
+	r.Protocol, r.Algorithm = b[2], b[3]
+	r.PublicKey = append([]byte(nil), b[4:]...)
+	return nil
+}
+
+// HexString is binary data shown as upper case hex, in JSON as well.
+type HexString []byte
+
+func (h HexString) String() string {
+	return strings.ToUpper(hex.EncodeToString(h))
+}
+
+func (h HexString) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+func (h *HexString) UnmarshalText(b []byte) error {
+	data, err := hex.DecodeString(strings.ReplaceAll(string(b), " ", ""))
+	if err != nil {
+		return errors.New("invalid hex data")
+	}
+	*h = data
+	return nil
+}
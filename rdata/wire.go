@@ -0,0 +1,98 @@
+package rdata
+
+import (
+	"dns-server/dnsname"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+var errShort = errors.New("rdata too short")
+
+// appendName appends name in uncompressed wire format.
+func appendName(b []byte, name string) ([]byte, error) {
+	name, err := dnsname.Canonical(name)
+	if err != nil {
+		return nil, err
+	}
+	if name != "." {
+		for _, label := range strings.Split(name[:len(name)-1], ".") {
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+	}
+	return append(b, 0), nil
+}
+
+// readName reads an uncompressed name; types without a well-known format
+// must not use compression inside RDATA (RFC 3597 4).
+func readName(b []byte) (string, []byte, error) {
+	var sb strings.Builder
+	for {
+		if len(b) == 0 {
+			return "", nil, errShort
+		}
+		n := int(b[0])
+		b = b[1:]
+		if n == 0 {
+			break
+		}
+		if n > 63 {
+			return "", nil, errors.New("compressed or invalid name")
+		}
+		if len(b) < n {
+			return "", nil, errShort
+		}
+		sb.Write(b[:n])
+		sb.WriteByte('.')
+		b = b[n:]
+	}
+	if sb.Len() == 0 {
+		return ".", b, nil
+	}
+	return strings.ToLower(sb.String()), b, nil
+}
+
+func appendString(b []byte, s string) ([]byte, error) {
+	if len(s) > 255 {
+		return nil, errors.New("character-string longer than 255 bytes")
+	}
+	b = append(b, byte(len(s)))
+	return append(b, s...), nil
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) == 0 || len(b) < 1+int(b[0]) {
+		return "", nil, errShort
+	}
+	n := int(b[0])
+	return string(b[1 : 1+n]), b[1+n:], nil
+}
+
+func readUint8(b []byte) (uint8, []byte, error) {
+	if len(b) < 1 {
+		return 0, nil, errShort
+	}
+	return b[0], b[1:], nil
+}
+
+func readUint16(b []byte) (uint16, []byte, error) {
+	if len(b) < 2 {
+		return 0, nil, errShort
+	}
+	return binary.BigEndian.Uint16(b), b[2:], nil
+}
+
+func readUint32(b []byte) (uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, errShort
+	}
+	return binary.BigEndian.Uint32(b), b[4:], nil
+}
+
+func done(b []byte) error {
+	if len(b) != 0 {
+		return errors.New("trailing data in rdata")
+	}
+	return nil
+}
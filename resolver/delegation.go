@@ -0,0 +1,173 @@
+package resolver
+
+import (
+	"dns-server/rdata"
+	"dns-server/types"
+	"dns-server/upstream"
+	"dns-server/view"
+	"errors"
+	"net/netip"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxReferrals limits how many referrals are followed for one query.
+const maxReferrals = 8
+
+var errNoServers = errors.New("delegation has no reachable name servers")
+
+// delegation finds the local zone q.Name is in and the zone cut at or
+// above q.Name inside it: the apex is the closest enclosing name with a
+// local SOA record, and the cut is the NS set of the highest name below
+// the apex with local NS records. NS records cached from upstream never
+// create a cut. DS records at the cut belong to the parent and are not
+// delegated. apex is "" outside local zones.
+//
+// The names are walked from the top down and the walk stops at the first
+// name with nothing local at or below it, so a name outside every local
+// zone costs one Exists.
+func (r *Resolver) delegation(v *view.View, q types.DNSQuestion) (apex string, cut []types.DNSRecord) {
+	var names []string
+	for d := q.Name; d != "" && d != "."; d = parent(d) {
+		names = append(names, d)
+	}
+	for i := len(names) - 1; i >= 0; i-- {
+		d := names[i]
+		if !r.store.Exists(v.Name, d) {
+			break
+		}
+		if r.localHas(v, d, rdata.TypeSOA) {
+			apex, cut = d, nil
+			continue
+		}
+		if cut != nil || apex == "" || (d == q.Name && q.Type == rdata.TypeDS) {
+			continue
+		}
+		cut, _ = r.localGet(types.DNSQuestion{Name: d, Type: rdata.TypeNS, View: v.Name})
+	}
+	return apex, cut
+}
+
+func (r *Resolver) localHas(v *view.View, name string, t types.RecordType) bool {
+	_, ok := r.localGet(types.DNSQuestion{Name: name, Type: t, View: v.Name})
+	return ok
+}
+
+// localGet returns the records for q that were entered locally. Records
+// cached from upstream share the store but never make a zone local.
+func (r *Resolver) localGet(q types.DNSQuestion) ([]types.DNSRecord, bool) {
+	records, _ := r.store.Get(q)
+	local := records[:0:0]
+	for _, rec := range records {
+		if rec.Local {
+			local = append(local, rec)
+		}
+	}
+	return local, len(local) > 0
+}
+
+// stored returns the records for q from the store: local ones always, and
+// answers cached from upstream only for clients that may recurse.
+func (r *Resolver) stored(q types.DNSQuestion, recurse bool) ([]types.DNSRecord, bool) {
+	if recurse {
+		return r.store.Get(q)
+	}
+	return r.localGet(q)
+}
+
+func parent(name string) string {
+	i := strings.IndexByte(name, '.')
+	if i < 0 || i == len(name)-1 {
+		return ""
+	}
+	return name[i+1:]
+}
+
+// follow asks the servers of a delegation for q, following further
+// referrals, and caches the answer like an upstream one.
+func (r *Resolver) follow(v *view.View, q types.DNSQuestion, ref types.DNSResponse) (types.DNSResponse, error) {
+	if records, ok := r.store.Get(q); ok {
+		r.logger.Info("CACHE HIT: " + q.Name + viewSuffix(v))
+		resp := types.DNSResponse{Records: records}
+		r.sections.get(q, &resp)
+		return resp, nil
+	}
+
+	for range maxReferrals {
+		resp, err := r.queryServers(v, q, ref)
+		if err != nil {
+			r.logger.Info("DELEGATION FAIL: " + q.Name + ": " + err.Error())
+			return types.DNSResponse{}, err
+		}
+		if isReferral(resp) {
+			ref = resp
+			continue
+		}
+
+		r.logger.Info("DELEGATION OK: " + q.Name)
+		for _, rec := range resp.Records {
+			rec.View = v.Name
+			r.store.Set(rec)
+		}
+		if len(resp.Records) > 0 {
+			r.sections.put(q, resp)
+		}
+		return resp, nil
+	}
+	return types.DNSResponse{}, errors.New("too many referrals for " + q.Name)
+}
+
+// queryServers sends q to the name servers in the authority section of
+// ref, using the glue in its additional section or, failing that, the
+// addresses the server names resolve to.
+func (r *Resolver) queryServers(v *view.View, q types.DNSQuestion, ref types.DNSResponse) (types.DNSResponse, error) {
+	var addrs []netip.Addr
+	for _, ns := range ref.Authority {
+		if ns.Type != rdata.TypeNS {
+			continue
+		}
+		host := targetOf(ns)
+		var known []netip.Addr
+		for _, rec := range ref.Additional {
+			if strings.EqualFold(rec.Name, host) {
+				known = append(known, addresses([]types.DNSRecord{rec})...)
+			}
+		}
+		if len(known) == 0 {
+			for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+				resp, _ := r.lookup(v, types.DNSQuestion{Name: host, Type: types.RecordType(t), View: v.Name}, true)
+				known = append(known, addresses(resp.Records)...)
+			}
+		}
+		addrs = append(addrs, known...)
+	}
+
+	err := errNoServers
+	for _, addr := range addrs {
+		var resp types.DNSResponse
+		resp, err = upstream.NewUDPUpstream(netip.AddrPortFrom(addr, 53).String()).Query(q)
+		if err == nil {
+			return resp, nil
+		}
+	}
+	return types.DNSResponse{}, err
+}
+
+// isReferral reports whether resp sends the query on to other servers
+// instead of answering it.
+func isReferral(resp types.DNSResponse) bool {
+	if resp.RCode != int(dnsmessage.RCodeSuccess) || len(resp.Records) > 0 {
+		return false
+	}
+	hasNS := false
+	for _, rec := range resp.Authority {
+		switch rec.Type {
+		case rdata.TypeSOA:
+			return false
+		case rdata.TypeNS:
+			hasNS = true
+		}
+	}
+	return hasNS
+}
@@ -0,0 +1,138 @@
+package resolver_test
+
+import (
+	"context"
+	"dns-server/identity"
+	"dns-server/resolver"
+	"dns-server/storage"
+	"dns-server/types"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// records give the mutated names something to match: a zone with a
+// wildcard, a delegation and a CNAME chain.
+var records = []types.DNSRecord{
+	{Name: "example.com.", Type: 6, Value: "ns.example.com. hostmaster.example.com. 1 7200 3600 1209600 300", TTL: 300},
+	{Name: "example.com.", Type: 15, Value: "10 mail.example.com.", TTL: 300},
+	{Name: "mail.example.com.", Type: 1, Value: "192.0.2.25", TTL: 300},
+	{Name: "*.preview.example.com.", Type: 1, Value: "192.0.2.10", TTL: 300},
+	{Name: "*.alias.example.com.", Type: 5, Value: "www.example.com.", TTL: 300},
+	{Name: "www.example.com.", Type: 5, Value: "mail.example.com.", TTL: 300},
+	{Name: "team.example.com.", Type: 2, Value: "ns1.team.example.com.", TTL: 300},
+	{Name: "ns1.team.example.com.", Type: 1, Value: "127.0.0.1", TTL: 300}, // پرسش دنبال‌شده فوراً رد می‌شود
+	{Name: "_sip._udp.example.com.", Type: 33, Value: "10 5 5060 mail.example.com.", TTL: 300},
+	{Name: "example.com.", Type: 16, Value: `"v=spf1 -all"`, TTL: 300},
+}
+
+// upstream answers every question itself, so that nothing leaves the
+// machine and cached answers are built from fuzzed names. Its addresses
+// are loopback too, since delegations are followed to them on port 53.
+type upstream struct{}
+
+func (upstream) Query(q types.DNSQuestion) (types.DNSResponse, error) {
+	if q.Type != types.RecordType(dnsmessage.TypeA) {
+		return types.DNSResponse{RCode: int(dnsmessage.RCodeNameError)}, nil
+	}
+	return types.DNSResponse{Records: []types.DNSRecord{
+		{Name: q.Name, Type: q.Type, Value: "127.0.0.1", TTL: 60},
+	}}, nil
+}
+
+type nolog struct{}
+
+func (nolog) Info(string) {}
+
+func seeds(t testing.TB) [][]byte {
+	var out [][]byte
+	qs := []struct {
+		name string
+		t    dnsmessage.Type
+	}{
+		{"version.bind.", dnsmessage.TypeTXT},
+		{"example.com.", dnsmessage.TypeSOA},
+		{"example.com.", dnsmessage.TypeMX},
+		{"example.com.", dnsmessage.TypeTXT},
+		{"a.b.preview.example.com.", dnsmessage.TypeA},
+		{"x.alias.example.com.", dnsmessage.TypeA},
+		{"host.team.example.com.", dnsmessage.TypeA},
+		{"team.example.com.", dnsmessage.Type(43)},
+		{"_sip._udp.example.com.", dnsmessage.TypeSRV},
+		{"WWW.Example.COM.", dnsmessage.TypeAAAA},
+		{"xn--bcher-kva.example.", dnsmessage.TypeA},
+		{"1.2.0.192.in-addr.arpa.", dnsmessage.TypePTR},
+		{"example.com.", dnsmessage.TypeAXFR},
+	}
+	for i, q := range qs {
+		class := dnsmessage.ClassINET
+		if i == 0 {
+			class = dnsmessage.ClassCHAOS
+		}
+		msg := dnsmessage.Message{
+			Header: dnsmessage.Header{ID: uint16(i), RecursionDesired: i%2 == 0},
+			Questions: []dnsmessage.Question{{
+				Name:  dnsmessage.MustNewName(q.name),
+				Type:  q.t,
+				Class: class,
+			}},
+		}
+		// نیمی از پرسش‌ها با EDNS(0)
+		if i%2 == 1 {
+			var h dnsmessage.ResourceHeader
+			h.SetEDNS0(4096, dnsmessage.RCodeSuccess, true)
+			msg.Additionals = []dnsmessage.Resource{{
+				Header: h,
+				Body:   &dnsmessage.OPTResource{Options: []dnsmessage.Option{{Code: 11}, {Code: 3}}},
+			}}
+		}
+		packet, err := msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, packet)
+	}
+	return out
+}
+
+// FuzzResolve feeds mutated queries to the resolver. It fails on a panic,
+// and on a response that does not parse or does not carry the query's ID.
+//
+//	go test -fuzz=FuzzResolve ./resolver
+func FuzzResolve(f *testing.F) {
+	for _, s := range seeds(f) {
+		f.Add(s)
+	}
+
+	store := storage.NewMemoryStorage()
+	for _, rec := range records {
+		rec.Local = true
+		store.Set(rec)
+	}
+	id := identity.DefaultConfig()
+	id.NSID = "dns-fuzz"
+	res := resolver.New(store, upstream{}, nolog{}, resolver.WithIdentity(identity.New(id)))
+
+	f.Fuzz(func(t *testing.T, in []byte) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		resp, err := res.ResolveRequest(ctx, &types.Request{
+			Msg:       in,
+			Client:    netip.MustParseAddrPort("192.0.2.99:5353"),
+			Transport: types.TransportUDP,
+		})
+		if err != nil || resp == nil || resp.Drop {
+			return
+		}
+
+		var msg dnsmessage.Message
+		if err := msg.Unpack(resp.Msg); err != nil {
+			t.Fatalf("unparsable response: %v", err)
+		}
+		if len(in) >= 2 && msg.Header.ID != uint16(in[0])<<8|uint16(in[1]) {
+			t.Fatalf("response ID %d does not match the query", msg.Header.ID)
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package resolver
+
+import (
+	"dns-server/rdata"
+	"dns-server/types"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// chaos answers the server identification names in class CHAOS with TXT
+// records. Names that are not served, or are disabled, are refused.
+func (r *Resolver) chaos(header dnsmessage.Header, ra bool, q dnsmessage.Question) (*types.Response, error) {
+	text, ok := r.identity.TXT(q.Name.String())
+	if !ok {
+		return r.buildErrorResponse(header, ra, dnsmessage.RCodeRefused, q)
+	}
+
+	hdr := replyHeader(header, ra, dnsmessage.RCodeSuccess)
+	hdr.Authoritative = true
+	msg := dnsmessage.Message{
+		Header:    hdr,
+		Questions: []dnsmessage.Question{q},
+	}
+	if q.Type == dnsmessage.TypeTXT || q.Type == dnsmessage.TypeALL {
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{
+				Name:  q.Name,
+				Type:  dnsmessage.TypeTXT,
+				Class: dnsmessage.ClassCHAOS,
+			},
+			Body: &dnsmessage.TXTResource{TXT: rdata.Chunks(text)},
+		}}
+	}
+	return pack(msg, nil)
+}
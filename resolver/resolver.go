@@ -3,11 +3,23 @@ package resolver
 
 import (
 	"context"
+	"dns-server/acl"
+	"dns-server/blocklist"
+	"dns-server/edns"
+	"dns-server/group"
+	"dns-server/hosts"
+	"dns-server/identity"
+	"dns-server/rdata"
+	"dns-server/rewrite"
+	"dns-server/rpz"
+	"dns-server/tsig"
 	"dns-server/types"
+	"dns-server/view"
+	"errors"
 	"fmt"
-	"net"
-	"strconv"
+	"net/netip"
 	"strings"
+	"time"
 
 	"golang.org/x/net/dns/dnsmessage"
 )
@@ -16,194 +28,745 @@ type Resolver struct {
 	store    types.Storage
 	upstream types.UpStream
 	logger   Logger
+	acl      *acl.ACL
+	views    *view.Set
+	blocking *blocklist.Filter
+	policy   *rpz.Policy
+	groups   *group.Set
+	rewrites *rewrite.Engine
+	hosts    *hosts.Source
+	identity *identity.Identity
+	sections *sectionCache
+}
+
+type Option func(*Resolver)
+
+// WithACL restricts which clients may query, recurse and transfer.
+func WithACL(a *acl.ACL) Option {
+	return func(r *Resolver) {
+		r.acl = a
+	}
+}
+
+// WithViews enables split-horizon views and the TSIG keys they use.
+func WithViews(v *view.Set) Option {
+	return func(r *Resolver) {
+		r.views = v
+	}
+}
+
+// WithBlocking answers queries for blocked domains locally.
+func WithBlocking(f *blocklist.Filter) Option {
+	return func(r *Resolver) {
+		r.blocking = f
+	}
+}
+
+// WithGroups selects the block lists for each client by its group.
+func WithGroups(g *group.Set) Option {
+	return func(r *Resolver) {
+		r.groups = g
+	}
+}
+
+// WithRewrites answers matching names with the rewrite rules.
+func WithRewrites(e *rewrite.Engine) Option {
+	return func(r *Resolver) {
+		r.rewrites = e
+	}
+}
+
+// WithHosts answers names from hosts and DHCP lease files before asking
+// upstream.
+func WithHosts(h *hosts.Source) Option {
+	return func(r *Resolver) {
+		r.hosts = h
+	}
+}
+
+// WithRPZ applies response policy zones to queries and their answers.
+func WithRPZ(p *rpz.Policy) Option {
+	return func(r *Resolver) {
+		r.policy = p
+	}
+}
+
+// WithIdentity sets the answers to the CHAOS identification names and the
+// NSID option. Without it identity.DefaultConfig is used.
+func WithIdentity(i *identity.Identity) Option {
+	return func(r *Resolver) {
+		r.identity = i
+	}
 }
 
 type Logger interface {
 	Info(msg string)
 }
 
-func New(store types.Storage, upstream types.UpStream, logger Logger) *Resolver {
-	return &Resolver{
+func New(store types.Storage, upstream types.UpStream, logger Logger, opts ...Option) *Resolver {
+	r := &Resolver{
 		store:    store,
 		upstream: upstream,
 		logger:   logger,
+		sections: newSectionCache(),
+		// CHAOS از بررسی کلاس مستثنی است و همیشه پاسخ دارد
+		identity: identity.New(identity.DefaultConfig()),
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
+	return r
 }
 
+// Resolve answers a raw query. It is kept for callers that only have the
+// message; client details are taken from ctx when a transport stored them
+// with types.WithRequest.
 func (r *Resolver) Resolve(
 	ctx context.Context,
 	req []byte,
 ) ([]byte, error) {
+	request, ok := types.RequestFromContext(ctx)
+	if !ok {
+		request = &types.Request{}
+	}
+	withMsg := *request
+	withMsg.Msg = req
 
-	var p dnsmessage.Parser
-
-	header, err := p.Start(req)
+	resp, err := r.ResolveRequest(ctx, &withMsg)
 	if err != nil {
 		return nil, err
 	}
+	return resp.Msg, nil
+}
+
+func (r *Resolver) ResolveRequest(
+	ctx context.Context,
+	req *types.Request,
+) (*types.Response, error) {
+
+	var p dnsmessage.Parser
 
-	q, err := p.Question()
+	header, err := p.Start(req.Msg)
 	if err != nil {
+		return nil, fmt.Errorf("%w: %v", types.ErrMalformed, err)
+	}
+	recurse := r.offersRecursion(req.Client)
+
+	if header.OpCode != opcodeQuery && header.OpCode != opcodeUpdate {
+		return r.buildErrorResponse(header, recurse, dnsmessage.RCodeNotImplemented)
+	}
+
+	questions, err := p.AllQuestions()
+	if err != nil || len(questions) != 1 {
+		return r.buildErrorResponse(header, recurse, dnsmessage.RCodeFormatError)
+	}
+	q := questions[0]
+
+	sig, err := tsig.Verify(req.Msg, r.views.Keys())
+	switch {
+	case err == nil, errors.Is(err, tsig.ErrNoSignature):
+	case errors.Is(err, tsig.ErrFormat):
+		return r.buildErrorResponse(header, recurse, dnsmessage.RCodeFormatError, q)
+	default:
+		r.logger.Info("NOTAUTH: " + err.Error() + " from " + req.Client.String())
+		resp, berr := r.buildErrorResponse(header, recurse, rcodeNotAuth, q)
+		if berr != nil {
+			return resp, berr
+		}
+		// خطای TSIG در رکورد TSIG پاسخ به کلاینت گفته می‌شود
+		resp.Msg, berr = tsig.Reject(resp.Msg, req.Msg, r.views.Keys(), err)
+		return resp, berr
+	}
+
+	keyName := ""
+	if sig != nil {
+		keyName = sig.Key.Name
+	}
+
+	resp, err := r.resolve(req, header, recurse, q, keyName)
+	if err != nil || resp.Drop {
+		return resp, err
+	}
+	resp.Msg = edns.WithNSID(req.Msg, resp.Msg, r.identity.NSID())
+	resp.Msg = edns.WithKeepalive(req.Msg, resp.Msg, req.Keepalive)
+	if req.MaxSize > 0 {
+		if resp.Msg, err = edns.Fit(resp.Msg, req.MaxSize); err != nil {
+			return nil, err
+		}
+	}
+	if sig == nil {
+		return resp, nil
+	}
+
+	// پاسخ به پرسش امضاشده باید با همان کلید امضا شود
+	unsigned := resp.Msg
+	resp.Resign = func(msg []byte) ([]byte, error) { return tsig.Sign(msg, sig) }
+	if resp.Msg, err = resp.Resign(unsigned); err != nil {
 		return nil, err
 	}
+	if req.MaxSize > 0 && len(resp.Msg) > req.MaxSize {
+		// رکورد TSIG هم باید جا شود؛ پیام بدون امضا کوچک‌تر و دوباره امضا می‌شود
+		trimmed, err := edns.Fit(unsigned, req.MaxSize-(len(resp.Msg)-len(unsigned)))
+		if err != nil {
+			return nil, err
+		}
+		resp.Msg, err = resp.Resign(trimmed)
+		return resp, err
+	}
+	return resp, nil
+}
 
+func (r *Resolver) resolve(
+	req *types.Request,
+	header dnsmessage.Header,
+	recurse bool,
+	q dnsmessage.Question,
+	keyName string,
+) (*types.Response, error) {
 	question := types.DNSQuestion{
 		Name: q.Name.String(),
 		Type: types.RecordType(q.Type),
 	}
 
-	if records, ok := r.store.Get(question); ok {
-		r.logger.Info("CACHE HIT: " + question.Name)
-		return r.buildResponse(header, question, records)
+	// کلاینت‌های داخلی (بدون آدرس) محدود نمی‌شوند
+	client := req.Client
+	hasClient := client.IsValid()
+	clientIP := client.Addr()
+
+	if hasClient && !r.acl.AllowQuery(clientIP) {
+		r.logger.Info("REFUSED (query acl): " + client.String() + " over " + string(req.Transport))
+		return r.buildErrorResponse(header, recurse, dnsmessage.RCodeRefused, q)
+	}
+
+	// کلاس IN داده دارد؛ CHAOS فقط نام‌های شناسایی سرور
+	switch q.Class {
+	case dnsmessage.ClassINET:
+	case dnsmessage.ClassCHAOS:
+		return r.chaos(header, recurse, q)
+	case dnsmessage.ClassHESIOD:
+		return r.buildErrorResponse(header, recurse, dnsmessage.RCodeRefused, q)
+	default:
+		return r.buildErrorResponse(header, recurse, dnsmessage.RCodeNotImplemented, q)
+	}
+
+	v, ok := r.views.Select(req, keyName)
+	if !ok {
+		r.logger.Info("REFUSED (no view): " + client.String())
+		return r.buildErrorResponse(header, recurse, dnsmessage.RCodeRefused, q)
+	}
+	question.View = v.Name
+
+	if isTransfer(header, q) {
+		if hasClient && !r.acl.AllowTransfer(clientIP) {
+			r.logger.Info("REFUSED (transfer acl): " + client.String())
+			return r.buildErrorResponse(header, recurse, dnsmessage.RCodeRefused, q)
+		}
+		return r.buildErrorResponse(header, recurse, dnsmessage.RCodeNotImplemented, q)
+	}
+
+	// تریگرهای QNAME پیش از resolve بررسی می‌شوند
+	checkResponse := r.policy != nil
+	if r.policy != nil {
+		if hit, ok := r.policy.QName(question.Name); ok {
+			if r.policy.ResponseFirst(hit) {
+				// zoneهای پیش از zone این hit با تریگرهای پاسخ مقدم‌اند
+				if answer, ref, err := r.answer(v, header, recurse, question); err == nil && !ref {
+					hit = r.responseHit(v, recurse, question, answer, hit)
+				}
+			}
+			r.logHit(hit, req, question)
+			switch hit.Action {
+			case rpz.ActionPassthru:
+				checkResponse = false
+			case rpz.ActionTCPOnly:
+				if req.Transport == types.TransportUDP {
+					return r.buildTruncated(header, recurse, question)
+				}
+				checkResponse = false
+			default:
+				return r.applyPolicy(hit, req, header, recurse, question, v)
+			}
+		}
+	}
+
+	if r.blocking != nil {
+		groupName, mask := "", blocklist.All
+		if r.groups != nil && hasClient {
+			groupName, mask = r.groups.Mask(clientIP, r.blocking, time.Now())
+		}
+		if list, blocked := r.blocking.Match(question.Name, mask); blocked {
+			msg := "BLOCKED: " + question.Name + " (" + list.Name + ")"
+			if groupName != "" {
+				msg += " for group " + groupName
+			}
+			r.logger.Info(msg)
+			records, nxdomain := r.blocking.Answer(question)
+			if nxdomain {
+				return r.buildErrorResponse(header, recurse, dnsmessage.RCodeNameError, q)
+			}
+			return r.buildResponse(header, recurse, question, records)
+		}
+	}
+
+	// بازنویسی‌ها بر رکوردهای محلی و کش مقدم هستند
+	if r.rewrites != nil {
+		if rule, records, ok := r.rewrites.Rewrite(question); ok {
+			r.logger.Info("REWRITE: " + question.Name + " (" + rule.Name + ")")
+			return r.buildResponse(header, recurse, question, r.chase(v, req, question, records))
+		}
+	}
+
+	answer, ref, err := r.answer(v, header, recurse, question)
+	if ref {
+		r.logger.Info("REFERRAL: " + question.Name + " to " + answer.Authority[0].Name)
+		return r.buildMessage(header, recurse, question, answer, false)
+	}
+	if errors.Is(err, errNoRecursion) {
+		r.logger.Info("REFUSED (recursion acl): " + question.Name + " from " + client.String())
+		return r.buildErrorResponse(header, recurse, dnsmessage.RCodeRefused, q)
+	}
+	if err != nil {
+		return r.buildErrorResponse(header, recurse, dnsmessage.RCodeServerFailure, q)
+	}
+
+	if checkResponse {
+		if hit := r.responseHit(v, recurse, question, answer, nil); hit != nil {
+			r.logHit(hit, req, question)
+			switch hit.Action {
+			case rpz.ActionPassthru:
+			case rpz.ActionTCPOnly:
+				if req.Transport == types.TransportUDP {
+					return r.buildTruncated(header, recurse, question)
+				}
+			default:
+				return r.applyPolicy(hit, req, header, recurse, question, v)
+			}
+		}
+	}
+
+	return r.buildMessage(header, recurse, question, r.glue(v.Name, answer), answer.Authoritative)
+}
+
+var errNoRecursion = errors.New("recursion not allowed")
+
+// answer resolves question in view v: through the servers of the local
+// delegation covering it, or with lookup. ref is set, with the referral in
+// the answer, when the client does not get recursion for a delegated name.
+func (r *Resolver) answer(
+	v *view.View,
+	header dnsmessage.Header,
+	recurse bool,
+	question types.DNSQuestion,
+) (answer types.DNSResponse, ref bool, err error) {
+	apex, cut := r.delegation(v, question)
+	if cut == nil {
+		answer, err = r.lookupIn(v, apex, question, recurse)
+		return answer, false, err
+	}
+	referral := r.glue(v.Name, types.DNSResponse{Authority: cut})
+	if !header.RecursionDesired || !recurse {
+		return referral, true, nil
+	}
+	answer, err = r.follow(v, question, referral)
+	return answer, false, err
+}
+
+// responseHit checks answer against the RPZ response triggers, of the
+// zones before the zone of the QNAME hit qhit when it is set. It returns
+// the hit that decides: a response trigger that matched, or else qhit.
+func (r *Resolver) responseHit(
+	v *view.View,
+	recurse bool,
+	question types.DNSQuestion,
+	answer types.DNSResponse,
+	qhit *rpz.Hit,
+) *rpz.Hit {
+	ns := func() ([]string, []netip.Addr) {
+		return r.nameServers(v, question.Name, recurse)
+	}
+	if hit, ok := r.policy.Response(addresses(answer.Records), ns, qhit); ok {
+		return hit
+	}
+	return qhit
+}
+
+// lookup answers q from the local records and cache of view v, then from
+// the hosts files, and finally from upstream. Without recurse, cached
+// upstream answers are not used and nothing is asked upstream. CNAMEs and
+// wildcards in local data are followed first. The answer is authoritative
+// only when every record in it was entered locally or comes from the
+// hosts files.
+func (r *Resolver) lookup(v *view.View, q types.DNSQuestion, recurse bool) (types.DNSResponse, error) {
+	return r.lookupIn(v, r.zoneOf(v, q), q, recurse)
+}
+
+// lookupIn is lookup for a name whose local zone is already known; apex
+// is "" outside local zones.
+func (r *Resolver) lookupIn(v *view.View, apex string, q types.DNSQuestion, recurse bool) (types.DNSResponse, error) {
+	var chain []types.DNSRecord
+	local := true
+	for range maxChain {
+		if records, ok := r.stored(q, recurse); ok {
+			r.logger.Info("CACHE HIT: " + q.Name + viewSuffix(v))
+			resp := types.DNSResponse{Records: records}
+			r.sections.get(q, &resp)
+			resp.Authoritative = local && allLocal(records)
+			return withChain(chain, resp), nil
+		}
+		resp, ok := r.synthesize(v, apex, q, recurse)
+		if !ok {
+			break
+		}
+		local = local && allLocal(resp.Records)
+		target, ok := aliasTarget(resp.Records, q.Type)
+		if !ok {
+			resp.Authoritative = local
+			return withChain(chain, resp), nil
+		}
+		chain = append(chain, resp.Records...)
+		q.Name = target
+		apex = r.zoneOf(v, q)
+	}
+
+	if r.hosts != nil {
+		if records, ok := r.hosts.Lookup(q); ok {
+			r.logger.Info("HOSTS: " + q.Name)
+			return withChain(chain, types.DNSResponse{Records: records, Authoritative: local}), nil
+		}
+	}
+
+	if !recurse {
+		if len(chain) > 0 {
+			return types.DNSResponse{Records: chain, Authoritative: local}, nil
+		}
+		return types.DNSResponse{}, errNoRecursion
 	}
 
-	r.logger.Info("CACHE MISS: " + question.Name)
+	r.logger.Info("CACHE MISS: " + q.Name + viewSuffix(v))
 
-	resp, err := r.upstream.Query(question)
+	resp, err := v.Upstream(q.Name, r.upstream).Query(q)
 	if err != nil {
-		r.logger.Info("UPSTREAM FAIL: " + question.Name)
-		return r.buildErrorResponse(header, dnsmessage.RCodeServerFailure)
+		r.logger.Info("UPSTREAM FAIL: " + q.Name)
+		return types.DNSResponse{}, err
 	}
 
-	r.logger.Info("UPSTREAM OK: " + question.Name)
+	r.logger.Info("UPSTREAM OK: " + q.Name)
 
+	// هر view کش جداگانه خود را دارد
 	for _, rec := range resp.Records {
+		rec.View = v.Name
 		r.store.Set(rec)
 	}
+	if len(resp.Records) > 0 {
+		r.sections.put(q, resp)
+	}
+	return withChain(chain, resp), nil
+}
+
+// allLocal reports whether none of records was cached from upstream.
+func allLocal(records []types.DNSRecord) bool {
+	for _, rec := range records {
+		if !rec.Local {
+			return false
+		}
+	}
+	return true
+}
+
+// withChain puts the CNAMEs that led to resp in front of its answer.
+func withChain(chain []types.DNSRecord, resp types.DNSResponse) types.DNSResponse {
+	if len(chain) > 0 {
+		resp.Records = append(chain[:len(chain):len(chain)], resp.Records...)
+	}
+	return resp
+}
+
+// applyPolicy builds the answer for an RPZ hit whose action replaces the
+// normal response.
+func (r *Resolver) applyPolicy(
+	hit *rpz.Hit,
+	req *types.Request,
+	header dnsmessage.Header,
+	recurse bool,
+	question types.DNSQuestion,
+	v *view.View,
+) (*types.Response, error) {
+	switch hit.Action {
+	case rpz.ActionNXDomain:
+		return r.buildMessage(header, recurse, question, types.DNSResponse{RCode: int(dnsmessage.RCodeNameError)}, true)
+	case rpz.ActionNoData:
+		return r.buildResponse(header, recurse, question, nil)
+	case rpz.ActionDrop:
+		return &types.Response{Drop: true}, nil
+	}
+
+	records := hit.Answer(question.Name, question.Type)
+	return r.buildResponse(header, recurse, question, r.chase(v, req, question, records))
+}
+
+// chase follows a locally synthesized CNAME so the client gets the target
+// records in the same answer.
+func (r *Resolver) chase(
+	v *view.View,
+	req *types.Request,
+	question types.DNSQuestion,
+	records []types.DNSRecord,
+) []types.DNSRecord {
+	if len(records) != 1 || records[0].Type != types.RecordType(dnsmessage.TypeCNAME) ||
+		question.Type == types.RecordType(dnsmessage.TypeCNAME) {
+		return records
+	}
+	target := question
+	target.Name = records[0].Value
+	recurse := !req.Client.IsValid() || r.acl.AllowRecursion(req.Client.Addr())
+	if more, err := r.lookup(v, target, recurse); err == nil {
+		records = append(records, more.Records...)
+	}
+	return records
+}
+
+// nameServers finds the NS set of the closest enclosing zone of name and
+// the addresses of those servers, for RPZ NSDNAME and NSIP triggers.
+func (r *Resolver) nameServers(v *view.View, name string, recurse bool) ([]string, []netip.Addr) {
+	var names []string
+	for d := name; d != "" && d != "."; {
+		q := types.DNSQuestion{Name: d, Type: types.RecordType(dnsmessage.TypeNS), View: v.Name}
+		resp, _ := r.lookup(v, q, recurse)
+		for _, rec := range resp.Records {
+			if rec.Type == types.RecordType(dnsmessage.TypeNS) {
+				names = append(names, rec.Value)
+			}
+		}
+		if len(names) > 0 {
+			break
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+
+	var addrs []netip.Addr
+	for _, ns := range names {
+		for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			q := types.DNSQuestion{Name: ns, Type: types.RecordType(t), View: v.Name}
+			resp, _ := r.lookup(v, q, recurse)
+			addrs = append(addrs, addresses(resp.Records)...)
+		}
+	}
+	return names, addrs
+}
+
+func addresses(records []types.DNSRecord) []netip.Addr {
+	var out []netip.Addr
+	for _, rec := range records {
+		if rec.Type != types.RecordType(dnsmessage.TypeA) && rec.Type != types.RecordType(dnsmessage.TypeAAAA) {
+			continue
+		}
+		if ip, err := netip.ParseAddr(rec.Value); err == nil {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+func (r *Resolver) logHit(hit *rpz.Hit, req *types.Request, q types.DNSQuestion) {
+	r.logger.Info(fmt.Sprintf("RPZ %s: zone=%s trigger=%s rule=%s client=%s qname=%s",
+		hit.Action, hit.Zone, hit.Trigger, hit.Rule, req.Client, q.Name))
+}
+
+// rcodeNotAuth is returned for requests with a bad TSIG signature.
+const rcodeNotAuth = dnsmessage.RCode(9)
+
+func viewSuffix(v *view.View) string {
+	if v.Name == view.Default {
+		return ""
+	}
+	return " [" + v.Name + "]"
+}
+
+// Opcodes the server handles; others get NOTIMP.
+const (
+	opcodeQuery  = 0
+	opcodeUpdate = 5
+)
 
-	return r.buildResponse(header, question, resp.Records)
+// offersRecursion reports whether client may use recursion. Internal
+// clients (without an address) always may.
+func (r *Resolver) offersRecursion(client netip.AddrPort) bool {
+	return !client.IsValid() || r.acl.AllowRecursion(client.Addr())
+}
+
+// isTransfer reports whether the query is a zone transfer or a dynamic
+// update, both of which are governed by the transfer ACL.
+func isTransfer(h dnsmessage.Header, q dnsmessage.Question) bool {
+	const typeIXFR = dnsmessage.Type(251)
+	return h.OpCode == opcodeUpdate ||
+		q.Type == dnsmessage.TypeAXFR ||
+		q.Type == typeIXFR
 }
 
 func (r *Resolver) buildResponse(
 	reqHeader dnsmessage.Header,
+	ra bool,
 	q types.DNSQuestion,
 	records []types.DNSRecord,
-) ([]byte, error) {
-	hdr := dnsmessage.Header{
-		ID:                 reqHeader.ID,
-		Response:           true,
-		Authoritative:      true,
-		RecursionAvailable: true,
-		RCode:              dnsmessage.RCodeSuccess,
-	}
+) (*types.Response, error) {
+	return r.buildMessage(reqHeader, ra, q, types.DNSResponse{Records: records}, true)
+}
 
-	question := dnsmessage.Question{
-		Name:  dnsmessage.MustNewName(q.Name),
-		Type:  dnsmessage.Type(q.Type),
-		Class: dnsmessage.ClassINET,
+// buildMessage answers with every section of resp. Referrals are sent
+// with aa cleared.
+func (r *Resolver) buildMessage(
+	reqHeader dnsmessage.Header,
+	ra bool,
+	q types.DNSQuestion,
+	resp types.DNSResponse,
+	aa bool,
+) (*types.Response, error) {
+	hdr := replyHeader(reqHeader, ra, dnsmessage.RCode(resp.RCode))
+	hdr.Authoritative = aa
+
+	question, err := toQuestion(q)
+	if err != nil {
+		return r.buildErrorResponse(reqHeader, ra, dnsmessage.RCodeFormatError)
 	}
 
 	msg := dnsmessage.Message{
-		Header:    hdr,
-		Questions: []dnsmessage.Question{question},
+		Header:      hdr,
+		Questions:   []dnsmessage.Question{question},
+		Authorities: toResources(resp.Authority),
+		Additionals: toResources(resp.Additional),
 	}
-	for _, rec := range records {
+	var answered []types.DNSRecord
+	for _, rec := range resp.Records {
+		// نام ذخیره‌شده کوچک است؛ پاسخ با همان حروفی که پرسیده شده برمی‌گردد
+		if strings.EqualFold(rec.Name, q.Name) {
+			rec.Name = q.Name
+		}
 		res, err := toResource(rec)
 		if err != nil {
 			continue
 		}
 		msg.Answers = append(msg.Answers, res)
+		answered = append(answered, rec)
+	}
+
+	return pack(msg, answered)
+}
+
+// toResources converts a section, leaving out records that are not valid.
+func toResources(records []types.DNSRecord) []dnsmessage.Resource {
+	var out []dnsmessage.Resource
+	for _, rec := range records {
+		if res, err := toResource(rec); err == nil {
+			out = append(out, res)
+		}
 	}
+	return out
+}
 
-	return msg.Pack()
+func pack(msg dnsmessage.Message, records []types.DNSRecord) (*types.Response, error) {
+	buf, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	return &types.Response{
+		Msg:     buf,
+		RCode:   int(msg.Header.RCode),
+		Records: records,
+	}, nil
+}
+
+// toQuestion converts q, failing for names that cannot be sent, such as
+// ones longer than 255 bytes.
+func toQuestion(q types.DNSQuestion) (dnsmessage.Question, error) {
+	name, err := dnsmessage.NewName(q.Name)
+	if err != nil {
+		return dnsmessage.Question{}, err
+	}
+	return dnsmessage.Question{
+		Name:  name,
+		Type:  dnsmessage.Type(q.Type),
+		Class: dnsmessage.ClassINET,
+	}, nil
 }
 
 func toResource(rec types.DNSRecord) (dnsmessage.Resource, error) {
+	name, err := dnsmessage.NewName(rec.Name)
+	if err != nil {
+		return dnsmessage.Resource{}, err
+	}
 	h := dnsmessage.ResourceHeader{
-		Name:  dnsmessage.MustNewName(rec.Name),
+		Name:  name,
 		Type:  dnsmessage.Type(rec.Type),
 		Class: dnsmessage.ClassINET,
 		TTL:   rec.TTL,
 	}
 
-	switch dnsmessage.Type(rec.Type) {
-	case dnsmessage.TypeA:
-		ip := net.ParseIP(rec.Value).To4()
-		if ip == nil {
-			return dnsmessage.Resource{}, fmt.Errorf("invalid A record")
-		}
-		return dnsmessage.Resource{
-			Header: h,
-			Body:   &dnsmessage.AResource{A: [4]byte(ip)},
-		}, nil
-
-	case dnsmessage.TypeAAAA:
-		ip := net.ParseIP(rec.Value).To16()
-		if ip == nil {
-			return dnsmessage.Resource{}, fmt.Errorf("invalid AAAA record")
-		}
-		return dnsmessage.Resource{
-			Header: h,
-			Body:   &dnsmessage.AAAAResource{AAAA: [16]byte(ip)},
-		}, nil
-
-	case dnsmessage.TypeCNAME:
-		return dnsmessage.Resource{
-			Header: h,
-			Body: &dnsmessage.CNAMEResource{
-				CNAME: dnsmessage.MustNewName(rec.Value),
-			},
-		}, nil
-
-	case dnsmessage.TypeMX:
-		parts := strings.Fields(rec.Value)
-		if len(parts) < 2 {
-			return dnsmessage.Resource{}, fmt.Errorf("invalid MX record format")
-		}
-		pref, err := strconv.ParseUint(parts[0], 10, 16)
-		if err != nil {
-			return dnsmessage.Resource{}, fmt.Errorf("invalid MX preference: %v", err)
-		}
-		return dnsmessage.Resource{
-			Header: h,
-			Body: &dnsmessage.MXResource{
-				Pref: uint16(pref),
-				MX:   dnsmessage.MustNewName(parts[1]),
-			},
-		}, nil
-
-	case dnsmessage.TypeTXT:
-		return dnsmessage.Resource{
-			Header: h,
-			Body:   &dnsmessage.TXTResource{TXT: []string{rec.Value}},
-		}, nil
-
-	case dnsmessage.TypeNS:
-		return dnsmessage.Resource{
-			Header: h,
-			Body: &dnsmessage.NSResource{
-				NS: dnsmessage.MustNewName(rec.Value),
-			},
-		}, nil
-
-	case dnsmessage.TypePTR:
-		return dnsmessage.Resource{
-			Header: h,
-			Body: &dnsmessage.PTRResource{
-				PTR: dnsmessage.MustNewName(rec.Value),
-			},
-		}, nil
+	var body dnsmessage.ResourceBody
+	if len(rec.RData) > 0 {
+		body, err = rdata.WireBody(rec.Type, rec.RData)
+	} else {
+		body, err = rdata.Body(rec.Type, rec.Value)
+	}
+	if err != nil {
+		return dnsmessage.Resource{}, err
+	}
+	return dnsmessage.Resource{Header: h, Body: body}, nil
+}
 
-	default:
-		return dnsmessage.Resource{}, fmt.Errorf("unsupported record type")
+// buildTruncated answers with an empty, truncated response so that the
+// client retries over TCP.
+func (r *Resolver) buildTruncated(
+	reqHeader dnsmessage.Header,
+	ra bool,
+	q types.DNSQuestion,
+) (*types.Response, error) {
+	question, err := toQuestion(q)
+	if err != nil {
+		return r.buildErrorResponse(reqHeader, ra, dnsmessage.RCodeFormatError)
+	}
+	hdr := replyHeader(reqHeader, ra, dnsmessage.RCodeSuccess)
+	hdr.Truncated = true
+	msg := dnsmessage.Message{
+		Header:    hdr,
+		Questions: []dnsmessage.Question{question},
 	}
+	return pack(msg, nil)
 }
 
+// buildErrorResponse answers with rcode and no records, echoing the
+// question when it could be read.
 func (r *Resolver) buildErrorResponse(
 	reqHeader dnsmessage.Header,
+	ra bool,
 	rcode dnsmessage.RCode,
-) ([]byte, error) {
-	hdr := dnsmessage.Header{
-		ID:                 reqHeader.ID,
-		Response:           true,
-		Authoritative:      true,
-		RecursionAvailable: true,
-		RCode:              rcode,
-	}
+	questions ...dnsmessage.Question,
+) (*types.Response, error) {
+	hdr := replyHeader(reqHeader, ra, rcode)
+	// NXDOMAIN از داده‌ی محلی (مسدودسازی، RPZ) است؛ خطاها معتبر نیستند
+	hdr.Authoritative = rcode == dnsmessage.RCodeNameError
 
 	msg := dnsmessage.Message{
-		Header: hdr,
+		Header:    hdr,
+		Questions: questions,
 	}
 
-	return msg.Pack()
+	return pack(msg, nil)
+}
+
+// replyHeader starts the header of a response to reqHeader. ID, opcode, RD
+// and CD are echoed; ra tells whether recursion is offered to the client.
+func replyHeader(reqHeader dnsmessage.Header, ra bool, rcode dnsmessage.RCode) dnsmessage.Header {
+	return dnsmessage.Header{
+		ID:                 reqHeader.ID,
+		Response:           true,
+		OpCode:             reqHeader.OpCode,
+		RecursionDesired:   reqHeader.RecursionDesired,
+		RecursionAvailable: ra,
+		CheckingDisabled:   reqHeader.CheckingDisabled,
+		RCode:              rcode,
+	}
 }
@@ -0,0 +1,169 @@
+package resolver
+
+import (
+	"dns-server/dnsname"
+	"dns-server/rdata"
+	"dns-server/types"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// maxSections caps how many responses the section cache remembers.
+	maxSections = 10000
+	// sectionSweep is how often expired sections are dropped.
+	sectionSweep = time.Minute
+)
+
+// sectionCache keeps the authority and additional sections of upstream
+// responses. The store holds records by owner name and type, so it cannot
+// tell which response they belonged to.
+type sectionCache struct {
+	mu      sync.Mutex
+	entries map[string]sections
+	sweep   time.Time
+}
+
+type sections struct {
+	authority  []types.DNSRecord
+	additional []types.DNSRecord
+	expires    time.Time
+}
+
+func newSectionCache() *sectionCache {
+	return &sectionCache{entries: make(map[string]sections), sweep: time.Now()}
+}
+
+func sectionKey(q types.DNSQuestion) string {
+	return q.View + "|" + nameTypeKey(q.Name, q.Type)
+}
+
+func nameTypeKey(name string, t types.RecordType) string {
+	return dnsname.Fold(name) + "|" + strconv.Itoa(int(t))
+}
+
+// put remembers the sections of resp until the shortest TTL among them
+// runs out.
+func (c *sectionCache) put(q types.DNSQuestion, resp types.DNSResponse) {
+	if len(resp.Authority) == 0 && len(resp.Additional) == 0 {
+		return
+	}
+	ttl := ^uint32(0)
+	for _, rec := range append(resp.Authority[:len(resp.Authority):len(resp.Authority)], resp.Additional...) {
+		ttl = min(ttl, rec.TTL)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	k := sectionKey(q)
+	c.maybeSweep(now)
+	if _, ok := c.entries[k]; !ok && len(c.entries) >= maxSections {
+		// ورودی دلخواهی جا باز می‌کند؛ ترتیب پیمایش map تصادفی است
+		for old := range c.entries {
+			delete(c.entries, old)
+			break
+		}
+	}
+	c.entries[k] = sections{
+		authority:  resp.Authority,
+		additional: resp.Additional,
+		expires:    now.Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// maybeSweep drops expired sections once every sectionSweep. Called with
+// c.mu held.
+func (c *sectionCache) maybeSweep(now time.Time) {
+	if now.Sub(c.sweep) < sectionSweep {
+		return
+	}
+	c.sweep = now
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// get fills in the sections cached for q.
+func (c *sectionCache) get(q types.DNSQuestion, resp *types.DNSResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[sectionKey(q)]
+	if !ok || time.Now().After(e.expires) {
+		return
+	}
+	resp.Authority, resp.Additional = e.authority, e.additional
+}
+
+// glue adds A and AAAA records for the targets of NS, MX and SRV records
+// to the additional section, taken from local data only.
+func (r *Resolver) glue(view string, resp types.DNSResponse) types.DNSResponse {
+	have := map[string]bool{}
+	for _, rec := range resp.Additional {
+		have[nameTypeKey(rec.Name, rec.Type)] = true
+	}
+
+	for _, rec := range append(resp.Records[:len(resp.Records):len(resp.Records)], resp.Authority...) {
+		target := targetOf(rec)
+		if target == "" || target == "." {
+			continue
+		}
+		for _, t := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			q := types.DNSQuestion{Name: target, Type: types.RecordType(t), View: view}
+			k := nameTypeKey(q.Name, q.Type)
+			if have[k] {
+				continue
+			}
+			have[k] = true
+			resp.Additional = append(resp.Additional, r.local(q)...)
+		}
+	}
+	return resp
+}
+
+// local answers q from the records entered locally and the hosts files,
+// without asking upstream or using cached answers.
+func (r *Resolver) local(q types.DNSQuestion) []types.DNSRecord {
+	if records, ok := r.localGet(q); ok {
+		return records
+	}
+	if r.hosts != nil {
+		if records, ok := r.hosts.Lookup(q); ok {
+			return records
+		}
+	}
+	return nil
+}
+
+// targetOf returns the host name whose addresses belong in the additional
+// section for rec.
+func targetOf(rec types.DNSRecord) string {
+	var (
+		data rdata.RData
+		err  error
+	)
+	if len(rec.RData) > 0 {
+		data, err = rdata.FromWire(rec.Type, rec.RData)
+	} else {
+		data, err = rdata.Parse(rec.Type, rec.Value)
+	}
+	if err != nil {
+		return ""
+	}
+	switch d := data.(type) {
+	case *rdata.NS:
+		return d.Host
+	case *rdata.MX:
+		return d.Exchange
+	case *rdata.SRV:
+		return d.Target
+	}
+	return ""
+}
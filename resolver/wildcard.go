@@ -0,0 +1,106 @@
+package resolver
+
+import (
+	"dns-server/rdata"
+	"dns-server/types"
+	"dns-server/view"
+	"encoding/binary"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxChain limits how many CNAMEs are followed inside local data.
+const maxChain = 8
+
+// typeRRSIG is not in rdata's registry; RRSIGs are stored as opaque data.
+const typeRRSIG types.RecordType = 46
+
+// synthesize answers q from local data that is not stored under q itself:
+// a CNAME at the name (a cached one only with recurse), or a wildcard in
+// the local zone at apex.
+func (r *Resolver) synthesize(v *view.View, apex string, q types.DNSQuestion, recurse bool) (types.DNSResponse, bool) {
+	if q.Type != rdata.TypeCNAME {
+		alias := q
+		alias.Type = rdata.TypeCNAME
+		if records, ok := r.stored(alias, recurse); ok {
+			return types.DNSResponse{Records: records}, true
+		}
+	}
+	return r.wildcard(v, apex, q)
+}
+
+// wildcard synthesizes an answer from the wildcard at the closest encloser
+// of q.Name (RFC 4592) inside the local zone at apex, looking only at local
+// records. A name that exists, even as an empty non-terminal, is never
+// matched by a wildcard; like a wildcard without records of the type asked
+// for, it gets an empty answer (NODATA) with the zone's SOA. A name that
+// does not exist and has no wildcard gets NXDOMAIN with the SOA.
+func (r *Resolver) wildcard(v *view.View, apex string, q types.DNSQuestion) (types.DNSResponse, bool) {
+	if apex == "" {
+		return types.DNSResponse{}, false
+	}
+	if r.store.Exists(v.Name, q.Name) {
+		return r.noData(v, apex), true
+	}
+
+	encloser := parent(q.Name)
+	for encloser != apex && encloser != "" && !r.store.Exists(v.Name, encloser) {
+		encloser = parent(encloser)
+	}
+	source := "*." + encloser
+	if encloser == "" || !r.store.Exists(v.Name, source) {
+		resp := r.noData(v, apex)
+		resp.RCode = int(dnsmessage.RCodeNameError)
+		return resp, true
+	}
+
+	for _, t := range []types.RecordType{q.Type, rdata.TypeCNAME} {
+		records, ok := r.localGet(types.DNSQuestion{Name: source, Type: t, View: v.Name})
+		if !ok {
+			continue
+		}
+		records = append(records, r.signatures(v, source, t)...)
+		for i := range records {
+			records[i].Name = q.Name
+		}
+		return types.DNSResponse{Records: records}, true
+	}
+	return r.noData(v, apex), true
+}
+
+func (r *Resolver) noData(v *view.View, apex string) types.DNSResponse {
+	soa, _ := r.localGet(types.DNSQuestion{Name: apex, Type: rdata.TypeSOA, View: v.Name})
+	return types.DNSResponse{Authority: soa}
+}
+
+// signatures returns the RRSIGs stored at name that cover type t. Their
+// label count lets a validator see that the answer was synthesized.
+func (r *Resolver) signatures(v *view.View, name string, t types.RecordType) []types.DNSRecord {
+	sigs, _ := r.localGet(types.DNSQuestion{Name: name, Type: typeRRSIG, View: v.Name})
+	var out []types.DNSRecord
+	for _, sig := range sigs {
+		if len(sig.RData) >= 2 && types.RecordType(binary.BigEndian.Uint16(sig.RData)) == t {
+			out = append(out, sig)
+		}
+	}
+	return out
+}
+
+// zoneOf returns the apex of the local zone q.Name is in, or "" when it
+// is outside local zones or below a delegation.
+func (r *Resolver) zoneOf(v *view.View, q types.DNSQuestion) string {
+	q.View = v.Name
+	if apex, cut := r.delegation(v, q); cut == nil {
+		return apex
+	}
+	return ""
+}
+
+// aliasTarget returns the CNAME target to follow when records answer a
+// query of type t with an alias.
+func aliasTarget(records []types.DNSRecord, t types.RecordType) (string, bool) {
+	if t == rdata.TypeCNAME || len(records) == 0 || records[0].Type != rdata.TypeCNAME {
+		return "", false
+	}
+	return records[0].Value, true
+}
@@ -0,0 +1,336 @@
+// Package rewrite answers queries for configured names with a CNAME or
+// fixed addresses, including the built-in safe search rules.
+package rewrite
+
+import (
+	"dns-server/dnsname"
+	"dns-server/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	MatchExact  = "exact"
+	MatchSuffix = "suffix" // خود دامنه و همه زیردامنه‌ها
+	MatchRegex  = "regex"
+)
+
+type Rule struct {
+	Name      string   `json:"name"` // دامنه یا عبارت منظم
+	Match     string   `json:"match,omitempty"`
+	CNAME     string   `json:"cname,omitempty"`
+	Addresses []string `json:"addresses,omitempty"`
+	TTL       uint32   `json:"ttl,omitempty"`
+}
+
+type Config struct {
+	SafeSearch []string `json:"safe_search,omitempty"` // google، bing، duckduckgo، youtube، yandex
+	YouTube    string   `json:"youtube,omitempty"`     // strict یا moderate
+	Rules      []Rule   `json:"rules"`
+}
+
+type compiled struct {
+	Rule
+	re    *regexp.Regexp
+	addrs []netip.Addr
+}
+
+// table indexes a list of rules. Exact rules win over suffix rules, the
+// longest suffix wins, and regex rules are tried last in order.
+type table struct {
+	exact  map[string]*compiled
+	suffix map[string]*compiled
+	regex  []*compiled
+}
+
+type Engine struct {
+	path string
+
+	mu       sync.RWMutex
+	config   Config
+	custom   table
+	builtins table
+}
+
+// Load reads the rewrite configuration from path. A missing file gives an
+// engine without rules that is created on the first change; with an empty
+// path rules are kept in memory only.
+func Load(path string) (*Engine, error) {
+	var c Config
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &c); err != nil {
+				return nil, fmt.Errorf("rewrite: %s: %w", path, err)
+			}
+		}
+	}
+	return New(path, c)
+}
+
+func New(path string, c Config) (*Engine, error) {
+	e := &Engine{path: path, config: c}
+
+	var builtins []Rule
+	for _, engine := range c.SafeSearch {
+		rules, err := safeSearch(strings.ToLower(engine), strings.ToLower(c.YouTube))
+		if err != nil {
+			return nil, err
+		}
+		builtins = append(builtins, rules...)
+	}
+
+	var err error
+	if e.builtins, err = index(builtins); err != nil {
+		return nil, err
+	}
+	if e.custom, err = index(c.Rules); err != nil {
+		return nil, err
+	}
+	for i, r := range c.Rules {
+		e.config.Rules[i] = normalize(r)
+	}
+	return e, nil
+}
+
+func index(rules []Rule) (table, error) {
+	t := table{
+		exact:  make(map[string]*compiled),
+		suffix: make(map[string]*compiled),
+	}
+	for _, r := range rules {
+		c, err := compile(r)
+		if err != nil {
+			return t, err
+		}
+		switch c.Match {
+		case MatchExact:
+			t.exact[c.Name] = c
+		case MatchSuffix:
+			t.suffix[c.Name] = c
+		case MatchRegex:
+			t.regex = append(t.regex, c)
+		}
+	}
+	return t, nil
+}
+
+func normalize(r Rule) Rule {
+	if r.Match == "" {
+		r.Match = MatchExact
+	}
+	if r.Match != MatchRegex {
+		r.Name = dnsname.Fold(r.Name)
+	}
+	if r.CNAME != "" {
+		r.CNAME = dnsname.Fold(r.CNAME)
+	}
+	if r.TTL == 0 {
+		r.TTL = 300
+	}
+	return r
+}
+
+func compile(r Rule) (*compiled, error) {
+	r = normalize(r)
+	c := &compiled{Rule: r}
+
+	if strings.TrimSuffix(r.Name, ".") == "" {
+		return nil, errors.New("rewrite: missing name")
+	}
+	if (r.CNAME == "") == (len(r.Addresses) == 0) {
+		return nil, fmt.Errorf("rewrite: %s: needs exactly one of cname or addresses", r.Name)
+	}
+
+	switch r.Match {
+	case MatchExact, MatchSuffix:
+	case MatchRegex:
+		re, err := regexp.Compile(r.Name)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite: %s: %w", r.Name, err)
+		}
+		c.re = re
+	default:
+		return nil, fmt.Errorf("rewrite: %s: invalid match %q", r.Name, r.Match)
+	}
+
+	for _, a := range r.Addresses {
+		ip, err := netip.ParseAddr(a)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite: %s: invalid address %q", r.Name, a)
+		}
+		c.addrs = append(c.addrs, ip.Unmap())
+	}
+	return c, nil
+}
+
+func (t table) match(name string) *compiled {
+	if c, ok := t.exact[name]; ok {
+		return c
+	}
+	for d := name; d != ""; {
+		if c, ok := t.suffix[d]; ok {
+			return c
+		}
+		i := strings.IndexByte(d, '.')
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+	// عبارت منظم روی نام بدون نقطه پایانی اجرا می‌شود
+	bare := strings.TrimSuffix(name, ".")
+	for _, c := range t.regex {
+		if c.re.MatchString(bare) {
+			return c
+		}
+	}
+	return nil
+}
+
+// Rewrite returns the answer for q when a rule matches its name: the
+// CNAME of the rule, or its addresses of the queried type. Custom rules
+// take precedence over safe search rules.
+func (e *Engine) Rewrite(q types.DNSQuestion) (*Rule, []types.DNSRecord, bool) {
+	name := dnsname.Fold(q.Name)
+
+	e.mu.RLock()
+	c := e.custom.match(name)
+	if c == nil {
+		c = e.builtins.match(name)
+	}
+	e.mu.RUnlock()
+	if c == nil {
+		return nil, nil, false
+	}
+
+	var records []types.DNSRecord
+	if c.CNAME != "" {
+		records = append(records, types.DNSRecord{
+			Name:  q.Name,
+			Type:  types.RecordType(dnsmessage.TypeCNAME),
+			Value: c.CNAME,
+			TTL:   c.TTL,
+		})
+		return &c.Rule, records, true
+	}
+
+	for _, ip := range c.addrs {
+		t := types.RecordType(dnsmessage.TypeA)
+		if ip.Is6() {
+			t = types.RecordType(dnsmessage.TypeAAAA)
+		}
+		if t != q.Type {
+			continue
+		}
+		records = append(records, types.DNSRecord{
+			Name:  q.Name,
+			Type:  t,
+			Value: ip.String(),
+			TTL:   c.TTL,
+		})
+	}
+	return &c.Rule, records, true
+}
+
+// Config returns the configuration with the current custom rules.
+func (e *Engine) Config() Config {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	c := e.config
+	c.Rules = append([]Rule(nil), e.config.Rules...)
+	return c
+}
+
+// Put adds a custom rule, or replaces the rule with the same name and
+// match type, and saves the configuration.
+func (e *Engine) Put(r Rule) error {
+	if _, err := compile(r); err != nil {
+		return err
+	}
+	r = normalize(r)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rules := append([]Rule(nil), e.config.Rules...)
+	if i := find(rules, r.Name, r.Match); i >= 0 {
+		rules[i] = r
+	} else {
+		rules = append(rules, r)
+	}
+	return e.setRules(rules)
+}
+
+// Delete removes a custom rule and reports whether it existed.
+func (e *Engine) Delete(name, match string) (bool, error) {
+	r := normalize(Rule{Name: name, Match: match})
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	i := find(e.config.Rules, r.Name, r.Match)
+	if i < 0 {
+		return false, nil
+	}
+	rules := append([]Rule(nil), e.config.Rules[:i]...)
+	rules = append(rules, e.config.Rules[i+1:]...)
+	return true, e.setRules(rules)
+}
+
+func find(rules []Rule, name, match string) int {
+	for i, r := range rules {
+		if r.Name == name && r.Match == match {
+			return i
+		}
+	}
+	return -1
+}
+
+// setRules swaps in rules and saves them; the caller holds e.mu.
+func (e *Engine) setRules(rules []Rule) error {
+	t, err := index(rules)
+	if err != nil {
+		return err
+	}
+	e.custom = t
+	e.config.Rules = rules
+	return e.save()
+}
+
+func (e *Engine) save() error {
+	if e.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(e.config, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(e.path), ".rewrites-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), e.path)
+}
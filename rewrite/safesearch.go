@@ -0,0 +1,55 @@
+package rewrite
+
+import "fmt"
+
+// safeSearch returns the built-in rules of a search engine. youtube is the
+// YouTube restriction level, "strict" or "moderate".
+func safeSearch(engine, youtube string) ([]Rule, error) {
+	switch engine {
+	case "google":
+		return []Rule{{
+			Name:  `^(www\.)?google\.(com|[a-z]{2}|com?\.[a-z]{2})$`,
+			Match: MatchRegex,
+			CNAME: "forcesafesearch.google.com.",
+		}}, nil
+
+	case "bing":
+		return []Rule{{Name: "www.bing.com.", CNAME: "strict.bing.com."}}, nil
+
+	case "duckduckgo":
+		return cnames("safe.duckduckgo.com.",
+			"duckduckgo.com.", "www.duckduckgo.com.", "start.duckduckgo.com."), nil
+
+	case "youtube":
+		target := "restrict.youtube.com."
+		switch youtube {
+		case "", "strict":
+		case "moderate":
+			target = "restrictmoderate.youtube.com."
+		default:
+			return nil, fmt.Errorf("rewrite: invalid youtube mode %q", youtube)
+		}
+		return cnames(target,
+			"www.youtube.com.", "m.youtube.com.", "youtubei.googleapis.com.",
+			"youtube.googleapis.com.", "www.youtube-nocookie.com."), nil
+
+	case "yandex":
+		// Yandex برای جستجوی خانوادگی آدرس ثابت اعلام کرده است
+		var rules []Rule
+		for _, d := range []string{"ru", "ua", "by", "kz", "com", "com.tr"} {
+			rules = append(rules,
+				Rule{Name: "yandex." + d + ".", Addresses: []string{"213.180.193.56"}},
+				Rule{Name: "www.yandex." + d + ".", Addresses: []string{"213.180.193.56"}})
+		}
+		return rules, nil
+	}
+	return nil, fmt.Errorf("rewrite: unknown safe search engine %q", engine)
+}
+
+func cnames(target string, names ...string) []Rule {
+	rules := make([]Rule, len(names))
+	for i, n := range names {
+		rules[i] = Rule{Name: n, CNAME: target}
+	}
+	return rules
+}
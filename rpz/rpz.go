@@ -0,0 +1,533 @@
+// Package rpz evaluates DNS Response Policy Zones. Policy zones are read
+// from master files or transferred with AXFR and checked in the order they
+// are configured; the first zone with a matching trigger decides.
+package rpz
+
+import (
+	"context"
+	"dns-server/dnsname"
+	"dns-server/rdata"
+	"dns-server/types"
+	"dns-server/upstream"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ZoneConfig struct {
+	Name    string `json:"name"`
+	File    string `json:"file,omitempty"`
+	Primary string `json:"primary,omitempty"` // سرور اصلی برای AXFR
+	Refresh string `json:"refresh,omitempty"`
+}
+
+type Config struct {
+	Zones []ZoneConfig `json:"zones"`
+}
+
+// LoadConfig reads a JSON RPZ configuration file.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, fmt.Errorf("rpz: %s: %w", path, err)
+	}
+	return c, nil
+}
+
+type Trigger string
+
+const (
+	TriggerQName   Trigger = "qname"
+	TriggerIP      Trigger = "ip"
+	TriggerNSDName Trigger = "nsdname"
+	TriggerNSIP    Trigger = "nsip"
+)
+
+type Action string
+
+const (
+	ActionNXDomain  Action = "nxdomain"
+	ActionNoData    Action = "nodata"
+	ActionPassthru  Action = "passthru"
+	ActionDrop      Action = "drop"
+	ActionTCPOnly   Action = "tcp-only"
+	ActionLocalData Action = "local-data"
+)
+
+// rule is the policy attached to one owner name of a policy zone.
+type rule struct {
+	owner   string
+	action  Action
+	records []types.DNSRecord
+}
+
+// Hit describes the rule that matched a query.
+type Hit struct {
+	Zone    string
+	Trigger Trigger
+	Rule    string // نام مالک rule در policy zone
+	Action  Action
+	records []types.DNSRecord
+	zone    int // جایگاه zone در پیکربندی
+}
+
+// Answer returns the local data of the rule for a query of qname with
+// type qtype: records of that type, or the CNAME the rule rewrites to.
+// Wildcard CNAME targets ("*.example.") are expanded with qname.
+func (h *Hit) Answer(qname string, qtype types.RecordType) []types.DNSRecord {
+	var out []types.DNSRecord
+	for _, rec := range h.records {
+		if rec.Type != qtype && rec.Type != rdata.TypeCNAME {
+			continue
+		}
+		rec.Name = qname
+		if rec.Type == rdata.TypeCNAME && strings.HasPrefix(rec.Value, "*.") {
+			rec.Value = strings.TrimSuffix(qname, ".") + rec.Value[1:]
+			rec.RData = nil
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+// prefixSet finds the longest prefix containing an address.
+type prefixSet struct {
+	rules map[netip.Prefix]*rule
+	lens  []int // طول‌های موجود، از بلند به کوتاه
+}
+
+func (s *prefixSet) add(p netip.Prefix, r *rule) {
+	if s.rules == nil {
+		s.rules = make(map[netip.Prefix]*rule)
+	}
+	s.rules[p] = r
+	if !slices.Contains(s.lens, p.Bits()) {
+		s.lens = append(s.lens, p.Bits())
+		slices.Sort(s.lens)
+		slices.Reverse(s.lens)
+	}
+}
+
+func (s *prefixSet) match(ip netip.Addr) (*rule, int) {
+	ip = ip.Unmap()
+	for _, l := range s.lens {
+		if l > ip.BitLen() {
+			continue
+		}
+		p, err := ip.Prefix(l)
+		if err != nil {
+			continue
+		}
+		if r, ok := s.rules[p]; ok {
+			return r, l
+		}
+	}
+	return nil, -1
+}
+
+func (s *prefixSet) len() int {
+	return len(s.rules)
+}
+
+// nameSet holds exact and wildcard ("*.example.") name triggers.
+type nameSet struct {
+	exact map[string]*rule
+	wild  map[string]*rule // کلید: دامنه والد wildcard
+}
+
+func newNameSet() nameSet {
+	return nameSet{exact: make(map[string]*rule), wild: make(map[string]*rule)}
+}
+
+func (s nameSet) add(name string, r *rule) {
+	if parent, ok := strings.CutPrefix(name, "*."); ok {
+		s.wild[parent] = r
+		return
+	}
+	s.exact[name] = r
+}
+
+// match prefers an exact trigger, then the wildcard of the closest parent.
+func (s nameSet) match(name string) *rule {
+	if r, ok := s.exact[name]; ok {
+		return r
+	}
+	for d := name; ; {
+		i := strings.IndexByte(d, '.')
+		if i < 0 || i == len(d)-1 {
+			return nil
+		}
+		d = d[i+1:]
+		if r, ok := s.wild[d]; ok {
+			return r
+		}
+	}
+}
+
+func (s nameSet) len() int {
+	return len(s.exact) + len(s.wild)
+}
+
+type rules struct {
+	qname   nameSet
+	nsdname nameSet
+	ip      prefixSet
+	nsip    prefixSet
+}
+
+type Zone struct {
+	ZoneConfig
+	refresh time.Duration
+
+	rules atomic.Pointer[rules]
+	hits  atomic.Uint64
+
+	mu      sync.Mutex
+	updated time.Time
+	err     error
+}
+
+type Policy struct {
+	zones []*Zone
+}
+
+func New(c Config) (*Policy, error) {
+	p := &Policy{}
+	seen := make(map[string]bool)
+	for _, zc := range c.Zones {
+		zc.Name = dnsname.Fold(zc.Name)
+		if zc.Name == "." || seen[zc.Name] {
+			return nil, fmt.Errorf("rpz: missing or duplicate zone name %q", zc.Name)
+		}
+		if (zc.File == "") == (zc.Primary == "") {
+			return nil, fmt.Errorf("rpz: zone %s needs exactly one of file or primary", zc.Name)
+		}
+		seen[zc.Name] = true
+
+		z := &Zone{ZoneConfig: zc, refresh: time.Hour}
+		if zc.Refresh != "" {
+			d, err := time.ParseDuration(zc.Refresh)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("rpz: zone %s: invalid refresh %q", zc.Name, zc.Refresh)
+			}
+			z.refresh = d
+		}
+		p.zones = append(p.zones, z)
+	}
+	return p, nil
+}
+
+// Run loads every zone and reloads each on its own schedule until ctx is
+// cancelled.
+func (p *Policy) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, z := range p.zones {
+		z.reload()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(z.refresh)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					z.reload()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// reload reads the zone again. On failure the previous rules stay active.
+func (z *Zone) reload() {
+	records, err := z.fetch()
+	var rs *rules
+	if err == nil {
+		rs, err = compile(z.Name, records)
+	}
+
+	z.mu.Lock()
+	z.err = err
+	if err == nil {
+		z.updated = time.Now()
+	}
+	z.mu.Unlock()
+
+	if err != nil {
+		log.Printf("rpz %s: %v (keeping previous rules)", z.Name, err)
+		return
+	}
+	z.rules.Store(rs)
+	log.Printf("rpz %s: %d qname, %d ip, %d nsdname, %d nsip rules", z.Name,
+		rs.qname.len(), rs.ip.len(), rs.nsdname.len(), rs.nsip.len())
+}
+
+func (z *Zone) fetch() ([]types.DNSRecord, error) {
+	if z.Primary != "" {
+		return upstream.Transfer(z.Primary, z.Name)
+	}
+	f, err := os.Open(z.File)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseZone(f, z.Name)
+}
+
+// compile groups the records of a policy zone by owner and turns each
+// owner into a trigger and its action.
+func compile(zone string, records []types.DNSRecord) (*rules, error) {
+	byOwner := make(map[string][]types.DNSRecord)
+	var owners []string
+	for _, rec := range records {
+		owner := dnsname.Fold(rec.Name)
+		if owner == zone {
+			continue // SOA و NS خود zone
+		}
+		if !strings.HasSuffix(owner, "."+zone) {
+			return nil, fmt.Errorf("record %s is outside the zone", rec.Name)
+		}
+		if _, ok := byOwner[owner]; !ok {
+			owners = append(owners, owner)
+		}
+		byOwner[owner] = append(byOwner[owner], rec)
+	}
+
+	rs := &rules{qname: newNameSet(), nsdname: newNameSet()}
+	for _, owner := range owners {
+		r := &rule{owner: owner}
+		r.action, r.records = action(byOwner[owner])
+
+		rel := strings.TrimSuffix(owner, "."+zone)
+		labels := strings.Split(rel, ".")
+		switch labels[len(labels)-1] {
+		case "rpz-ip", "rpz-nsip":
+			prefix, err := parseIPTrigger(labels[:len(labels)-1])
+			if err != nil {
+				log.Printf("rpz %s: skipping %s: %v", zone, owner, err)
+				continue
+			}
+			if labels[len(labels)-1] == "rpz-ip" {
+				rs.ip.add(prefix, r)
+			} else {
+				rs.nsip.add(prefix, r)
+			}
+		case "rpz-nsdname":
+			rs.nsdname.add(strings.TrimSuffix(rel, "rpz-nsdname"), r)
+		case "rpz-client-ip":
+			log.Printf("rpz %s: skipping %s: client-ip triggers are not supported", zone, owner)
+		default:
+			rs.qname.add(rel+".", r)
+		}
+	}
+	return rs, nil
+}
+
+// action reads the policy encoded in the data of a trigger
+// (draft-vixie-dnsop-dns-rpz, section 4).
+func action(records []types.DNSRecord) (Action, []types.DNSRecord) {
+	for _, rec := range records {
+		if rec.Type != rdata.TypeCNAME {
+			continue
+		}
+		switch dnsname.Fold(rec.Value) {
+		case ".":
+			return ActionNXDomain, nil
+		case "*.":
+			return ActionNoData, nil
+		case "rpz-passthru.":
+			return ActionPassthru, nil
+		case "rpz-drop.":
+			return ActionDrop, nil
+		case "rpz-tcp-only.":
+			return ActionTCPOnly, nil
+		}
+	}
+	return ActionLocalData, records
+}
+
+// parseIPTrigger decodes the reversed prefix notation of rpz-ip and
+// rpz-nsip owners, e.g. "24.0.2.0.192" or "48.zz.1.db8.2001".
+func parseIPTrigger(labels []string) (netip.Prefix, error) {
+	if len(labels) < 2 {
+		return netip.Prefix{}, fmt.Errorf("short ip trigger")
+	}
+	bits, err := strconv.Atoi(labels[0])
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid prefix length %q", labels[0])
+	}
+
+	addr := slices.Clone(labels[1:])
+	slices.Reverse(addr)
+
+	var s string
+	if len(addr) == 4 && !slices.Contains(addr, "zz") {
+		s = strings.Join(addr, ".")
+	} else {
+		for i, a := range addr {
+			if a == "zz" {
+				addr[i] = ""
+			}
+		}
+		s = strings.Join(addr, ":")
+		// "zz" در ابتدا یا انتها به "::" تبدیل می‌شود
+		if strings.HasPrefix(s, ":") {
+			s = ":" + s
+		}
+		if strings.HasSuffix(s, ":") {
+			s += ":"
+		}
+	}
+
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	prefix, err := ip.Prefix(bits)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if prefix.Addr() != ip {
+		return netip.Prefix{}, fmt.Errorf("%s has host bits set", ip)
+	}
+	return prefix, nil
+}
+
+func (z *Zone) hit(i int, t Trigger, r *rule) *Hit {
+	z.hits.Add(1)
+	return &Hit{
+		Zone:    z.Name,
+		Trigger: t,
+		Rule:    r.owner,
+		Action:  r.action,
+		records: r.records,
+		zone:    i,
+	}
+}
+
+// QName checks the query name against the QNAME triggers. A zone
+// configured before the one that matched may still decide through its
+// response triggers; see ResponseFirst.
+func (p *Policy) QName(name string) (*Hit, bool) {
+	name = dnsname.Fold(name)
+	for i, z := range p.zones {
+		rs := z.rules.Load()
+		if rs == nil {
+			continue
+		}
+		if r := rs.qname.match(name); r != nil {
+			return z.hit(i, TriggerQName, r), true
+		}
+	}
+	return nil, false
+}
+
+// ResponseFirst reports whether a zone configured before the zone of the
+// QNAME hit has IP, NSDNAME or NSIP triggers. Then the answer has to be
+// checked with Response(…, hit) before hit is applied, since the first
+// zone with any matching trigger wins.
+func (p *Policy) ResponseFirst(hit *Hit) bool {
+	for _, z := range p.zones[:hit.zone] {
+		if rs := z.rules.Load(); rs != nil && rs.ip.len()+rs.nsdname.len()+rs.nsip.len() > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Response checks the answer addresses against the IP triggers and the
+// name servers of the query name against the NSDNAME and NSIP triggers.
+// Within a zone the order is IP, NSDNAME, NSIP. ns is only called when a
+// zone has name server triggers. With a QNAME hit in before, only the
+// zones configured ahead of its zone are checked.
+func (p *Policy) Response(addrs []netip.Addr, ns func() ([]string, []netip.Addr), before *Hit) (*Hit, bool) {
+	var (
+		nsLoaded bool
+		nsNames  []string
+		nsAddrs  []netip.Addr
+	)
+	zones := p.zones
+	if before != nil {
+		zones = zones[:before.zone]
+	}
+	for i, z := range zones {
+		rs := z.rules.Load()
+		if rs == nil {
+			continue
+		}
+		if r := longest(&rs.ip, addrs); r != nil {
+			return z.hit(i, TriggerIP, r), true
+		}
+		if rs.nsdname.len() == 0 && rs.nsip.len() == 0 {
+			continue
+		}
+
+		if !nsLoaded {
+			nsNames, nsAddrs = ns()
+			nsLoaded = true
+		}
+		for _, n := range nsNames {
+			if r := rs.nsdname.match(dnsname.Fold(n)); r != nil {
+				return z.hit(i, TriggerNSDName, r), true
+			}
+		}
+		if r := longest(&rs.nsip, nsAddrs); r != nil {
+			return z.hit(i, TriggerNSIP, r), true
+		}
+	}
+	return nil, false
+}
+
+func longest(s *prefixSet, addrs []netip.Addr) *rule {
+	var (
+		best    *rule
+		bestLen = -1
+	)
+	for _, ip := range addrs {
+		if r, l := s.match(ip); l > bestLen {
+			best, bestLen = r, l
+		}
+	}
+	return best
+}
+
+type ZoneStats struct {
+	Name    string    `json:"name"`
+	Rules   int       `json:"rules"`
+	Hits    uint64    `json:"hits"`
+	Updated time.Time `json:"updated"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func (p *Policy) Stats() []ZoneStats {
+	var st []ZoneStats
+	for _, z := range p.zones {
+		zs := ZoneStats{Name: z.Name, Hits: z.hits.Load()}
+		if rs := z.rules.Load(); rs != nil {
+			zs.Rules = rs.qname.len() + rs.ip.len() + rs.nsdname.len() + rs.nsip.len()
+		}
+		z.mu.Lock()
+		zs.Updated = z.updated
+		if z.err != nil {
+			zs.Error = z.err.Error()
+		}
+		z.mu.Unlock()
+		st = append(st, zs)
+	}
+	return st
+}
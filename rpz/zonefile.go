@@ -0,0 +1,228 @@
+package rpz
+
+import (
+	"bufio"
+	"dns-server/rdata"
+	"dns-server/types"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// nameFields gives the position of the domain name in the presentation
+// data of the types that have one, so that a relative name can be
+// completed with the origin before rdata parses it.
+var nameFields = map[types.RecordType]int{
+	rdata.TypeNS:    0,
+	rdata.TypeCNAME: 0,
+	rdata.TypePTR:   0,
+	rdata.TypeMX:    1,
+	rdata.TypeSRV:   3,
+}
+
+// parseZone reads a master file (RFC 1035 section 5). origin must be
+// absolute. Records of types the rdata package cannot parse are skipped
+// with a log line instead of failing the zone.
+func parseZone(r io.Reader, origin string) ([]types.DNSRecord, error) {
+	zone := origin
+	var (
+		records []types.DNSRecord
+		owner   = origin
+		ttl     = uint32(3600)
+		lineNo  int
+		pending string
+		depth   int
+	)
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		lineNo++
+		line := stripComment(sc.Text())
+
+		// رکوردهای چندخطی داخل پرانتز
+		depth += strings.Count(line, "(") - strings.Count(line, ")")
+		if pending != "" {
+			line = pending + " " + line
+		}
+		if depth > 0 {
+			pending = line
+			continue
+		}
+		pending = ""
+		line = strings.NewReplacer("(", " ", ")", " ").Replace(line)
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		startsBlank := line[0] == ' ' || line[0] == '\t'
+		fields := tokenize(line)
+
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: $ORIGIN needs a name", lineNo)
+			}
+			origin = absolute(fields[1], origin)
+			continue
+		case "$TTL":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: $TTL needs a value", lineNo)
+			}
+			v, err := parseTTL(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			ttl = v
+			continue
+		case "$INCLUDE":
+			return nil, fmt.Errorf("line %d: $INCLUDE is not supported", lineNo)
+		}
+
+		if !startsBlank {
+			owner = absolute(fields[0], origin)
+			fields = fields[1:]
+		}
+
+		recTTL := ttl
+		for len(fields) > 0 {
+			if v, err := parseTTL(fields[0]); err == nil {
+				recTTL = v
+				fields = fields[1:]
+				continue
+			}
+			if c := strings.ToUpper(fields[0]); c == "IN" || c == "CH" || c == "HS" {
+				fields = fields[1:]
+				continue
+			}
+			break
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: missing type", lineNo)
+		}
+
+		rtype, err := rdata.ParseType(fields[0])
+		if err != nil {
+			log.Printf("rpz %s: line %d: skipping record: %v", zone, lineNo, err)
+			continue
+		}
+		if rtype == rdata.TypeSOA {
+			continue
+		}
+
+		data := fields[1:]
+		if i, ok := nameFields[rtype]; ok && i < len(data) {
+			data[i] = absolute(data[i], origin)
+		}
+		value, err := rdata.Canonical(rtype, strings.Join(data, " "))
+		if err != nil {
+			log.Printf("rpz %s: line %d: skipping record: %v", zone, lineNo, err)
+			continue
+		}
+
+		records = append(records, types.DNSRecord{
+			Name:  owner,
+			Type:  rtype,
+			Value: value,
+			TTL:   recTTL,
+		})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced parentheses")
+	}
+	return records, nil
+}
+
+func absolute(name, origin string) string {
+	if name == "@" {
+		return origin
+	}
+	if strings.HasSuffix(name, ".") {
+		return strings.ToLower(name)
+	}
+	if origin == "." {
+		return strings.ToLower(name) + "."
+	}
+	return strings.ToLower(name) + "." + origin
+}
+
+// ttlUnits are the units of BIND's TTL format, such as 1h30m or 1w.
+var ttlUnits = map[byte]uint64{'s': 1, 'm': 60, 'h': 3600, 'd': 86400, 'w': 604800}
+
+// parseTTL reads a TTL in seconds or in BIND's unit format.
+func parseTTL(s string) (uint32, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(n), nil
+	}
+	var total, n uint64
+	digits := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= '0' && c <= '9':
+			n, digits = n*10+uint64(c-'0'), true
+		case digits && ttlUnits[c|0x20] > 0:
+			total += n * ttlUnits[c|0x20]
+			n, digits = 0, false
+		default:
+			return 0, fmt.Errorf("invalid ttl %q", s)
+		}
+		if n > math.MaxUint32 || total > math.MaxUint32 {
+			return 0, fmt.Errorf("invalid ttl %q", s)
+		}
+	}
+	// عدد پایانی بدون واحد پذیرفته نیست
+	if digits || s == "" {
+		return 0, fmt.Errorf("invalid ttl %q", s)
+	}
+	return uint32(total), nil
+}
+
+func stripComment(line string) string {
+	inQuote := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			inQuote = !inQuote
+		case ';':
+			if !inQuote {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+// tokenize splits on whitespace, keeping quoted strings together.
+func tokenize(line string) []string {
+	var (
+		out   []string
+		cur   strings.Builder
+		quote bool
+	)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '"':
+			quote = !quote
+			cur.WriteByte(c)
+		case (c == ' ' || c == '\t') && !quote:
+			if cur.Len() > 0 {
+				out = append(out, cur.String())
+				cur.Reset()
+			}
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	if cur.Len() > 0 {
+		out = append(out, cur.String())
+	}
+	return out
+}
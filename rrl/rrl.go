@@ -0,0 +1,277 @@
+// Package rrl implements DNS response rate limiting in the style of the
+// BIND/Knot RRL scheme: responses are accounted per client netblock and
+// response kind, and clients exceeding the rate get dropped or truncated
+// replies so that spoofed queries cannot be used for amplification.
+package rrl
+
+import (
+	"log"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+type Action int
+
+const (
+	Allow Action = iota // ارسال پاسخ کامل
+	Drop                // عدم ارسال پاسخ
+	Slip                // ارسال پاسخ کوتاه‌شده با بیت TC
+)
+
+type Kind int
+
+const (
+	KindAnswer Kind = iota
+	KindNXDomain
+	KindError
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindAnswer:
+		return "answer"
+	case KindNXDomain:
+		return "nxdomain"
+	default:
+		return "error"
+	}
+}
+
+type Config struct {
+	ResponsesPerSecond int // پاسخ‌های عادی به ازای هر نام و نوع
+	NXDomainsPerSecond int
+	ErrorsPerSecond    int
+	Window             time.Duration // بازه‌ای که بدهی کلاینت در آن حساب می‌شود
+	Slip               int           // هر N پاسخ محدودشده یکی کوتاه‌شده ارسال می‌شود (0 = هرگز)
+	Leak               int           // هر N پاسخ محدودشده یکی کامل ارسال می‌شود (0 = هرگز)
+	IPv4PrefixLen      int
+	IPv6PrefixLen      int
+	LogOnly            bool // فقط ثبت، بدون اعمال محدودیت
+}
+
+// DefaultConfig matches the defaults recommended for BIND's RRL.
+func DefaultConfig() Config {
+	return Config{
+		ResponsesPerSecond: 5,
+		NXDomainsPerSecond: 5,
+		ErrorsPerSecond:    5,
+		Window:             15 * time.Second,
+		Slip:               2,
+		IPv4PrefixLen:      24,
+		IPv6PrefixLen:      56,
+	}
+}
+
+type bucket struct {
+	balance float64
+	last    time.Time
+	limited uint64 // تعداد پاسخ‌های محدودشده از آخرین بازیابی
+}
+
+type Stats struct {
+	Responses uint64            `json:"responses"`
+	Limited   uint64            `json:"limited"`
+	Dropped   uint64            `json:"dropped"`
+	Slipped   uint64            `json:"slipped"`
+	Leaked    uint64            `json:"leaked"`
+	ByKind    map[string]uint64 `json:"limited_by_kind"`
+	Tracked   int               `json:"tracked_clients"`
+	LogOnly   bool              `json:"log_only"`
+}
+
+type Limiter struct {
+	cfg Config
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	sweep   time.Time
+
+	responses atomic.Uint64
+	limited   atomic.Uint64
+	dropped   atomic.Uint64
+	slipped   atomic.Uint64
+	leaked    atomic.Uint64
+	byKind    [3]atomic.Uint64
+}
+
+func New(cfg Config) *Limiter {
+	def := DefaultConfig()
+	if cfg.NXDomainsPerSecond <= 0 {
+		cfg.NXDomainsPerSecond = cfg.ResponsesPerSecond
+	}
+	if cfg.ErrorsPerSecond <= 0 {
+		cfg.ErrorsPerSecond = cfg.ResponsesPerSecond
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = def.Window
+	}
+	if cfg.IPv4PrefixLen <= 0 || cfg.IPv4PrefixLen > 32 {
+		cfg.IPv4PrefixLen = def.IPv4PrefixLen
+	}
+	if cfg.IPv6PrefixLen <= 0 || cfg.IPv6PrefixLen > 128 {
+		cfg.IPv6PrefixLen = def.IPv6PrefixLen
+	}
+
+	return &Limiter{
+		cfg:     cfg,
+		buckets: make(map[string]*bucket),
+		sweep:   time.Now(),
+	}
+}
+
+// Check accounts resp, about to be sent to client, and decides what to do
+// with it.
+func (l *Limiter) Check(client netip.Addr, resp []byte) Action {
+	l.responses.Add(1)
+
+	kind, name := classify(resp)
+	prefix := l.netblock(client)
+
+	var key string
+	if kind == KindAnswer {
+		key = prefix.String() + "|" + kind.String() + "|" + name
+	} else {
+		// NXDOMAIN و خطاها فقط بر اساس شبکه کلاینت شمرده می‌شوند تا
+		// پرسش از زیردامنه‌های تصادفی محدودیت را دور نزند
+		key = prefix.String() + "|" + kind.String()
+	}
+
+	now := time.Now()
+	rate := float64(l.rate(kind))
+
+	l.mu.Lock()
+	l.maybeSweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{balance: rate, last: now}
+		l.buckets[key] = b
+	}
+
+	b.balance += now.Sub(b.last).Seconds() * rate
+	if b.balance > rate {
+		b.balance = rate
+		b.limited = 0
+	}
+	b.last = now
+	b.balance--
+	if floor := -rate * l.cfg.Window.Seconds(); b.balance < floor {
+		b.balance = floor
+	}
+
+	if b.balance >= 0 {
+		l.mu.Unlock()
+		return Allow
+	}
+
+	b.limited++
+	n := b.limited
+	l.mu.Unlock()
+
+	l.limited.Add(1)
+	l.byKind[kind].Add(1)
+	if n == 1 {
+		log.Printf("rrl: limiting %s responses to %s (%s)", kind, prefix, name)
+	}
+
+	if l.cfg.LogOnly {
+		return Allow
+	}
+	if l.cfg.Leak > 0 && n%uint64(l.cfg.Leak) == 0 {
+		l.leaked.Add(1)
+		return Allow
+	}
+	if l.cfg.Slip > 0 && n%uint64(l.cfg.Slip) == 0 {
+		l.slipped.Add(1)
+		return Slip
+	}
+	l.dropped.Add(1)
+	return Drop
+}
+
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	tracked := len(l.buckets)
+	l.mu.Unlock()
+
+	byKind := make(map[string]uint64, len(l.byKind))
+	for k := range l.byKind {
+		byKind[Kind(k).String()] = l.byKind[k].Load()
+	}
+
+	return Stats{
+		Responses: l.responses.Load(),
+		Limited:   l.limited.Load(),
+		Dropped:   l.dropped.Load(),
+		Slipped:   l.slipped.Load(),
+		Leaked:    l.leaked.Load(),
+		ByKind:    byKind,
+		Tracked:   tracked,
+		LogOnly:   l.cfg.LogOnly,
+	}
+}
+
+func (l *Limiter) rate(k Kind) int {
+	switch k {
+	case KindNXDomain:
+		return l.cfg.NXDomainsPerSecond
+	case KindError:
+		return l.cfg.ErrorsPerSecond
+	default:
+		return l.cfg.ResponsesPerSecond
+	}
+}
+
+func (l *Limiter) netblock(ip netip.Addr) netip.Prefix {
+	ip = ip.Unmap()
+	bits := l.cfg.IPv6PrefixLen
+	if ip.Is4() {
+		bits = l.cfg.IPv4PrefixLen
+	}
+	p, err := ip.Prefix(bits)
+	if err != nil {
+		return netip.PrefixFrom(ip, ip.BitLen())
+	}
+	return p
+}
+
+// maybeSweep drops buckets that have fully recovered so the table does not
+// grow with every address that ever sent a query. Called with l.mu held.
+func (l *Limiter) maybeSweep(now time.Time) {
+	if now.Sub(l.sweep) < l.cfg.Window {
+		return
+	}
+	l.sweep = now
+	for k, b := range l.buckets {
+		if now.Sub(b.last) > l.cfg.Window {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+func classify(resp []byte) (Kind, string) {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return KindError, ""
+	}
+
+	name := ""
+	if q, err := p.Question(); err == nil {
+		name = strings.ToLower(q.Name.String()) + "/" + q.Type.String()
+	}
+
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+		return KindAnswer, name
+	case dnsmessage.RCodeNameError:
+		return KindNXDomain, name
+	default:
+		return KindError, name
+	}
+}
@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"dns-server/dnsname"
+	"dns-server/rdata"
 	"dns-server/types"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -9,23 +13,27 @@ import (
 type MemoryStorage struct {
 	mu      sync.RWMutex
 	records map[string][]types.DNSRecord
+	owners  map[string]int // view|name → تعداد رکوردهای محلی در این نام یا زیر آن
 }
 
 func NewMemoryStorage() *MemoryStorage {
 	return &MemoryStorage{
 		records: make(map[string][]types.DNSRecord),
+		owners:  make(map[string]int),
 	}
 }
 
-func key(name string, rtype types.RecordType) string {
-	return name + ":" + string(rune(rtype))
+// key is built from the canonical name, so lookups ignore case and a
+// missing trailing dot.
+func key(view string, name string, rtype types.RecordType) string {
+	return view + "|" + dnsname.Fold(name) + ":" + strconv.Itoa(int(rtype))
 }
 
 func (m *MemoryStorage) Get(q types.DNSQuestion) ([]types.DNSRecord, bool) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	k := key(q.Name, q.Type)
+	k := key(q.View, q.Name, q.Type)
 	recs, ok := m.records[k]
 	if !ok {
 		return nil, false
@@ -51,27 +59,68 @@ func (m *MemoryStorage) Set(r types.DNSRecord) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	rdata.Normalize(&r) // مقدار نامعتبر همان‌طور که هست نگه داشته می‌شود
+	r.Name = dnsname.Fold(r.Name)
 	r.ExpiresAt = time.Now().Add(time.Duration(r.TTL) * time.Second)
-	k := key(r.Name, r.Type)
+	k := key(r.View, r.Name, r.Type)
 
+	// مثل SQLite، رکورد تکراری فقط TTL را تازه می‌کند
+	for i, existing := range m.records[k] {
+		if existing.Value == r.Value {
+			if existing.Local && !r.Local {
+				return // کش جای رکورد محلی را نمی‌گیرد
+			}
+			if r.Local && !existing.Local {
+				m.own(r, 1)
+			}
+			// رکورد دستی با ساخت خودکار همان PTR خودکار نمی‌شود
+			r.Generated = r.Generated && existing.Generated
+			m.records[k][i] = r
+			return
+		}
+	}
 	m.records[k] = append(m.records[k], r)
+	if r.Local {
+		m.own(r, 1)
+	}
+}
+
+// own counts a local record at its owner name and every name above it,
+// which is what Exists looks up.
+func (m *MemoryStorage) own(r types.DNSRecord, delta int) {
+	if !r.Local {
+		return
+	}
+	for name := r.Name; ; {
+		k := r.View + "|" + name
+		if m.owners[k] += delta; m.owners[k] <= 0 {
+			delete(m.owners, k)
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 || i == len(name)-1 {
+			break
+		}
+		name = name[i+1:]
+	}
 }
 
-func (m *MemoryStorage) Delete(name string, rtype types.RecordType, value string) {
+func (m *MemoryStorage) Delete(view string, name string, rtype types.RecordType, value string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	k := key(name, rtype)
-	if value == "" {
-		// delete all
+	k := key(view, name, rtype)
+	var filtered []types.DNSRecord
+	for _, r := range m.records[k] {
+		// مقدار خالی یعنی حذف همه
+		if value == "" || r.Value == value {
+			m.own(r, -1)
+		} else {
+			filtered = append(filtered, r)
+		}
+	}
+	if len(filtered) == 0 {
 		delete(m.records, k)
 	} else {
-		var filtered []types.DNSRecord
-		for _, r := range m.records[k] {
-			if r.Value != value {
-				filtered = append(filtered, r)
-			}
-		}
 		m.records[k] = filtered
 	}
 }
@@ -91,6 +140,14 @@ func (m *MemoryStorage) List() []types.DNSRecord {
 	return all
 }
 
+func (m *MemoryStorage) Exists(view string, name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// رکوردهای منقضی تا CleanupExpired شمرده می‌شوند
+	return m.owners[view+"|"+dnsname.Fold(name)] > 0
+}
+
 func (m *MemoryStorage) CleanupExpired() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -101,6 +158,8 @@ func (m *MemoryStorage) CleanupExpired() error {
 		for _, r := range recs {
 			if r.ExpiresAt.After(now) {
 				filtered[k] = append(filtered[k], r)
+			} else {
+				m.own(r, -1)
 			}
 		}
 	}
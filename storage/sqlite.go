@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"dns-server/dnsname"
+	"dns-server/rdata"
 	"dns-server/types"
+	"slices"
+	"strings"
 	"time"
 
 	"gorm.io/driver/sqlite"
@@ -17,9 +21,14 @@ type DBRecord struct {
 	ID        uint `gorm:"primarykey"`
 	Name      string
 	Type      uint16
+	View      string `gorm:"not null;default:''"`
 	Value     string
+	RData     []byte `gorm:"column:rdata"` // قالب wire؛ برای رکوردهای قدیمی در migrate پر می‌شود
 	TTL       uint32
 	ExpiresAt time.Time
+	Local     bool
+	Generated bool   `gorm:"not null;default:false"` // PTR ساخته‌شده خودکار؛ رکوردهای قدیمی دستی حساب می‌شوند
+	RName     string `gorm:"column:rname"`           // نام با برچسب‌های معکوس، برای Exists
 }
 
 func (DBRecord) TableName() string {
@@ -38,18 +47,112 @@ func NewSQLiteStorage(path string) (*SQLiteStorage, error) {
 		return nil, err
 	}
 
-	db.Exec("CREATE INDEX IF NOT EXISTS idx_name_type ON records(name, type)")
+	db.Exec("DROP INDEX IF EXISTS idx_name_type")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_view_name_type ON records(view, name, type)")
 	db.Exec("CREATE INDEX IF NOT EXISTS idx_expires ON records(expires_at)")
+	db.Exec("CREATE INDEX IF NOT EXISTS idx_view_rname ON records(view, rname)")
+
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+	if err := migrateNames(db); err != nil {
+		return nil, err
+	}
+	if err := migrateLocal(db); err != nil {
+		return nil, err
+	}
 
 	return &SQLiteStorage{db: db}, nil
 }
 
+// migrate fills the rdata column of records stored before it existed and
+// rewrites their values in canonical form. Values that cannot be parsed are
+// left alone and still served from the value column.
+func migrate(db *gorm.DB) error {
+	var old []DBRecord
+	if err := db.Where("rdata IS NULL").Find(&old).Error; err != nil {
+		return err
+	}
+	for _, dbRec := range old {
+		data, err := rdata.Legacy(types.RecordType(dbRec.Type), dbRec.Value)
+		if err != nil {
+			continue
+		}
+		wire, err := rdata.Wire(data)
+		if err != nil {
+			continue
+		}
+		err = db.Model(&DBRecord{}).Where("id = ?", dbRec.ID).
+			Updates(map[string]any{"value": data.String(), "rdata": wire}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateNames rewrites names stored before they were canonicalized: upper
+// case, without the trailing dot or with Unicode labels.
+func migrateNames(db *gorm.DB) error {
+	var old []DBRecord
+	err := db.Where("name <> lower(name) OR substr(name, -1) <> '.' OR name GLOB ?", "*[^ -~]*").
+		Find(&old).Error
+	if err != nil {
+		return err
+	}
+	for _, dbRec := range old {
+		name, err := dnsname.Canonical(dbRec.Name)
+		if err != nil || name == dbRec.Name {
+			continue
+		}
+		err = db.Model(&DBRecord{}).Where("id = ?", dbRec.ID).
+			Updates(map[string]any{"name": name, "rname": reverseName(name)}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateLocal fills the local and rname columns of records stored before
+// they existed. Old databases did not tell records entered by the admin
+// from cached ones, so all are taken as local; cached records expire with
+// their TTL anyway.
+func migrateLocal(db *gorm.DB) error {
+	if err := db.Exec("UPDATE records SET local = 1 WHERE local IS NULL").Error; err != nil {
+		return err
+	}
+	var old []DBRecord
+	if err := db.Where("rname IS NULL").Find(&old).Error; err != nil {
+		return err
+	}
+	for _, dbRec := range old {
+		err := db.Model(&DBRecord{}).Where("id = ?", dbRec.ID).Update("rname", reverseName(dbRec.Name)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reverseName turns www.example.com. into com.example.www., so that a name
+// and everything below it are one range of an index.
+func reverseName(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return ""
+	}
+	labels := strings.Split(name, ".")
+	slices.Reverse(labels)
+	return strings.Join(labels, ".") + "."
+}
+
 func (s *SQLiteStorage) Get(q types.DNSQuestion) ([]types.DNSRecord, bool) {
 	var dbRecs []DBRecord
 	now := time.Now()
 
-	result := s.db.Where("name = ? AND type = ? AND expires_at > ?",
-		q.Name, uint16(q.Type), now).Find(&dbRecs)
+	result := s.db.Where("view = ? AND name = ? AND type = ? AND expires_at > ?",
+		q.View, dnsname.Fold(q.Name), uint16(q.Type), now).Find(&dbRecs)
 	if result.Error != nil || result.RowsAffected == 0 {
 		return nil, false
 	}
@@ -59,9 +162,13 @@ func (s *SQLiteStorage) Get(q types.DNSQuestion) ([]types.DNSRecord, bool) {
 		recs[i] = types.DNSRecord{
 			Name:      dbRec.Name,
 			Type:      types.RecordType(dbRec.Type),
+			View:      dbRec.View,
 			Value:     dbRec.Value,
+			RData:     dbRec.RData,
 			TTL:       dbRec.TTL,
 			ExpiresAt: dbRec.ExpiresAt,
+			Local:     dbRec.Local,
+			Generated: dbRec.Generated,
 		}
 	}
 
@@ -69,35 +176,49 @@ func (s *SQLiteStorage) Get(q types.DNSQuestion) ([]types.DNSRecord, bool) {
 }
 
 func (s *SQLiteStorage) Set(r types.DNSRecord) {
+	rdata.Normalize(&r)
+	r.Name = dnsname.Fold(r.Name)
 	r.ExpiresAt = time.Now().Add(time.Duration(r.TTL) * time.Second)
 	dbRec := DBRecord{
 		Name:      r.Name,
 		Type:      uint16(r.Type),
+		View:      r.View,
 		Value:     r.Value,
+		RData:     r.RData,
 		TTL:       r.TTL,
 		ExpiresAt: r.ExpiresAt,
+		Local:     r.Local,
+		Generated: r.Generated,
+		RName:     reverseName(r.Name),
 	}
 
 	var existing DBRecord
-	result := s.db.Where("name = ? AND type = ? AND value = ?",
-		r.Name, uint16(r.Type), r.Value).First(&existing)
+	result := s.db.Where("view = ? AND name = ? AND type = ? AND value = ?",
+		r.View, r.Name, uint16(r.Type), r.Value).First(&existing)
 
 	if result.Error == nil {
+		if existing.Local && !r.Local {
+			return // کش جای رکورد محلی را نمی‌گیرد
+		}
 		existing.TTL = r.TTL
 		existing.ExpiresAt = r.ExpiresAt
+		existing.Local = r.Local
+		// رکورد دستی با ساخت خودکار همان PTR خودکار نمی‌شود
+		existing.Generated = existing.Generated && r.Generated
 		s.db.Save(&existing)
 	} else {
 		s.db.Create(&dbRec)
 	}
 }
 
-func (s *SQLiteStorage) Delete(name string, rtype types.RecordType, value string) {
+func (s *SQLiteStorage) Delete(view string, name string, rtype types.RecordType, value string) {
+	name = dnsname.Fold(name)
 	if value == "" {
 		// delete all
-		s.db.Where("name = ? AND type = ?", name, uint16(rtype)).Delete(&DBRecord{})
+		s.db.Where("view = ? AND name = ? AND type = ?", view, name, uint16(rtype)).Delete(&DBRecord{})
 	} else {
-		s.db.Where("name = ? AND type = ? AND value = ?",
-			name, uint16(rtype), value).Delete(&DBRecord{})
+		s.db.Where("view = ? AND name = ? AND type = ? AND value = ?",
+			view, name, uint16(rtype), value).Delete(&DBRecord{})
 	}
 }
 
@@ -112,15 +233,31 @@ func (s *SQLiteStorage) List() []types.DNSRecord {
 		recs[i] = types.DNSRecord{
 			Name:      dbRec.Name,
 			Type:      types.RecordType(dbRec.Type),
+			View:      dbRec.View,
 			Value:     dbRec.Value,
+			RData:     dbRec.RData,
 			TTL:       dbRec.TTL,
 			ExpiresAt: dbRec.ExpiresAt,
+			Local:     dbRec.Local,
+			Generated: dbRec.Generated,
 		}
 	}
 
 	return recs
 }
 
+func (s *SQLiteStorage) Exists(view string, name string) bool {
+	q := s.db.Model(&DBRecord{}).Where("view = ? AND local AND expires_at > ?", view, time.Now())
+	// نام و همه‌ی نام‌های زیر آن با یک بازه از ایندکس rname پیدا می‌شوند:
+	// از com.example. تا com.example/ ('/' پس از '.' می‌آید)
+	if r := reverseName(dnsname.Fold(name)); r != "" {
+		q = q.Where("rname >= ? AND rname < ?", r, strings.TrimSuffix(r, ".")+"/")
+	}
+	var found []DBRecord
+	q.Select("id").Limit(1).Find(&found)
+	return len(found) > 0
+}
+
 func (s *SQLiteStorage) Close() error {
 	sqlDB, err := s.db.DB()
 	if err != nil {
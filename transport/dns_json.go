@@ -2,10 +2,11 @@ package transport
 
 import (
 	"context"
+	"dns-server/dnsname"
+	"dns-server/rdata"
+	"dns-server/types"
 	"encoding/json"
-	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
 	"golang.org/x/net/dns/dnsmessage"
@@ -66,19 +67,29 @@ func (s *DoHServer) handleJSON(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	name, err = dnsname.Canonical(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	packet, err := buildDNSQuery(name, typ)
 	if err != nil {
-		http.Error(w, "dns build error", http.StatusInternalServerError)
+		http.Error(w, "invalid name", http.StatusBadRequest)
 		return
 	}
 
-	resp, err := s.resolver.Resolve(ctx, packet)
+	resp, err := resolve(ctx, s.resolver, httpRequest(r, packet, types.TransportJSON))
 	if err != nil {
 		http.Error(w, "resolver error", http.StatusInternalServerError)
 		return
 	}
+	if resp.Drop {
+		http.Error(w, "query dropped", http.StatusServiceUnavailable)
+		return
+	}
 
-	out, err := dnsToJSON(resp)
+	out, err := dnsToJSON(resp.Msg)
 	if err != nil {
 		http.Error(w, "parse error", http.StatusInternalServerError)
 		return
@@ -88,15 +99,16 @@ func (s *DoHServer) handleJSON(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(out)
 }
 
+// buildDNSQuery packs a query for name, which must be canonical.
 func buildDNSQuery(name string, t dnsmessage.Type) ([]byte, error) {
-	if !strings.HasSuffix(name, ".") {
-		name += "."
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
 	}
-
 	msg := dnsmessage.Message{
 		Header: dnsmessage.Header{ID: 1},
 		Questions: []dnsmessage.Question{{
-			Name:  dnsmessage.MustNewName(name),
+			Name:  qname,
 			Type:  t,
 			Class: dnsmessage.ClassINET,
 		}},
@@ -105,24 +117,8 @@ func buildDNSQuery(name string, t dnsmessage.Type) ([]byte, error) {
 }
 
 func parseType(t string) (dnsmessage.Type, error) {
-	switch strings.ToUpper(t) {
-	case "A":
-		return dnsmessage.TypeA, nil
-	case "AAAA":
-		return dnsmessage.TypeAAAA, nil
-	case "CNAME":
-		return dnsmessage.TypeCNAME, nil
-	case "MX":
-		return dnsmessage.TypeMX, nil
-	case "TXT":
-		return dnsmessage.TypeTXT, nil
-	case "NS":
-		return dnsmessage.TypeNS, nil
-	case "PTR":
-		return dnsmessage.TypePTR, nil
-	default:
-		return 0, fmt.Errorf("unsupported type")
-	}
+	typ, err := rdata.ParseType(t)
+	return dnsmessage.Type(typ), err
 }
 
 func dnsToJSON(resp []byte) (map[string]any, error) {
@@ -137,12 +133,20 @@ func dnsToJSON(resp []byte) (map[string]any, error) {
 
 	out := []map[string]any{}
 	for _, a := range answers {
-		out = append(out, map[string]any{
+		data, err := rdata.FromBody(a.Body)
+		if err != nil {
+			continue
+		}
+		answer := map[string]any{
 			"name": a.Header.Name.String(),
-			"type": a.Header.Type.String(),
+			"type": rdata.TypeName(types.RecordType(a.Header.Type)),
 			"ttl":  a.Header.TTL,
-			"data": a.Body,
-		})
+			"data": data.String(),
+		}
+		if u := dnsname.Unicode(a.Header.Name.String()); u != a.Header.Name.String() {
+			answer["unicode"] = u
+		}
+		out = append(out, answer)
 	}
 
 	return map[string]any{
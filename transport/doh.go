@@ -4,8 +4,10 @@ import (
 	"context"
 	"dns-server/types"
 	"encoding/base64"
+	"errors"
 	"io"
 	"net/http"
+	"net/netip"
 	"os"
 	"time"
 )
@@ -15,6 +17,7 @@ type DoHServer struct {
 	resolver types.Resolver
 	certFile string
 	keyFile  string
+	server   *http.Server
 }
 
 func NewDoHServer(
@@ -23,25 +26,28 @@ func NewDoHServer(
 	certFile string,
 	keyFile string,
 ) *DoHServer {
-	return &DoHServer{
+	s := &DoHServer{
 		addr:     addr,
 		resolver: r,
 		certFile: certFile,
 		keyFile:  keyFile,
 	}
-}
 
-func (s *DoHServer) ListenAndServe() error {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/dns-query", s.handleDNS)
 	mux.HandleFunc("/dns-query/json", s.handleJSON)
 
-	server := &http.Server{
-		Addr:         s.addr,
+	s.server = &http.Server{
+		Addr:         addr,
 		Handler:      mux,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 	}
+	return s
+}
+
+func (s *DoHServer) ListenAndServe() error {
+	server := s.server
 
 	// HTTP or HTTPS
 	if s.certFile != "" && s.keyFile != "" {
@@ -55,6 +61,23 @@ func (s *DoHServer) ListenAndServe() error {
 
 }
 
+// Shutdown gracefully stops the HTTP server; see http.Server.Shutdown.
+func (s *DoHServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// httpRequest describes a query received over HTTP.
+func httpRequest(r *http.Request, msg []byte, t types.Transport) *types.Request {
+	addr, _ := netip.ParseAddrPort(r.RemoteAddr)
+	return &types.Request{
+		Msg:       msg,
+		Client:    addr,
+		Transport: t,
+		TLS:       r.TLS,
+		HTTP:      r,
+	}
+}
+
 func (s *DoHServer) handleDNS(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet && r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -92,14 +115,22 @@ func (s *DoHServer) handleDNS(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	resp, err := s.resolver.Resolve(ctx, req)
+	resp, err := resolve(ctx, s.resolver, httpRequest(r, req, types.TransportDoH))
+	if errors.Is(err, types.ErrMalformed) {
+		http.Error(w, "Malformed query", http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Resolver error", http.StatusInternalServerError)
 		return
 	}
+	if resp.Drop {
+		http.Error(w, "Query dropped", http.StatusServiceUnavailable)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/dns-message")
 	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(http.StatusOK)
-	w.Write(resp)
+	w.Write(resp.Msg)
 }
@@ -0,0 +1,182 @@
+package transport
+
+import (
+	"context"
+	"dns-server/edns"
+	"dns-server/types"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"golang.org/x/net/quic"
+)
+
+// DoQ application error codes (RFC 9250 section 4.3).
+const (
+	doqNoError       = 0x0
+	doqInternalError = 0x1
+	doqProtocolError = 0x2
+)
+
+// DoQServer serves DNS over QUIC (RFC 9250). Each bidirectional stream
+// carries exactly one length-prefixed query and its response.
+type DoQServer struct {
+	addr        string
+	resolver    types.Resolver
+	certFile    string
+	keyFile     string
+	maxStreams  int64
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	endpoint *quic.Endpoint
+	closing  bool
+	ctx      context.Context // با Shutdown لغو می‌شود
+	cancel   context.CancelFunc
+	streams  sync.WaitGroup
+}
+
+func NewDoQServer(
+	addr string,
+	r types.Resolver,
+	certFile string,
+	keyFile string,
+) *DoQServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &DoQServer{
+		addr:        addr,
+		resolver:    r,
+		certFile:    certFile,
+		keyFile:     keyFile,
+		maxStreams:  100,
+		idleTimeout: 30 * time.Second,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+func (s *DoQServer) ListenAndServe() error {
+	certs, err := newCertReloader(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+
+	ep, err := quic.Listen("udp", s.addr, &quic.Config{
+		TLSConfig:            certs.tlsConfig("doq"),
+		MaxBidiRemoteStreams: s.maxStreams,
+		MaxUniRemoteStreams:  -1, // کلاینت مجاز به باز کردن stream یک‌طرفه نیست
+		MaxIdleTimeout:       s.idleTimeout,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		ep.Close(context.Background())
+		return ErrServerClosed
+	}
+	s.endpoint = ep
+	s.mu.Unlock()
+
+	for {
+		conn, err := ep.Accept(s.ctx)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return ErrServerClosed
+			}
+			ep.Close(context.Background())
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+// Shutdown stops accepting connections and streams, waits for queries in
+// progress to be answered and then closes every connection.
+func (s *DoQServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	ep := s.endpoint
+	s.mu.Unlock()
+
+	s.cancel()
+	err := waitGroupContext(ctx, &s.streams)
+	if ep != nil {
+		if cerr := ep.Close(ctx); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *DoQServer) handleConn(conn *quic.Conn) {
+	var inflight sync.WaitGroup
+	defer func() {
+		// اتصال پس از پاسخ به همه stream های باز بسته می‌شود
+		inflight.Wait()
+		conn.Close()
+	}()
+
+	for {
+		stream, err := conn.AcceptStream(s.ctx)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			return
+		}
+		s.streams.Add(1)
+		s.mu.Unlock()
+		inflight.Add(1)
+		go func() {
+			defer s.streams.Done()
+			defer inflight.Done()
+			s.handleStream(conn, stream)
+		}()
+	}
+}
+
+func (s *DoQServer) handleStream(conn *quic.Conn, stream *quic.Stream) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream.SetReadContext(ctx)
+	stream.SetWriteContext(ctx)
+
+	req, err := readMsg(stream)
+	if err != nil {
+		stream.Reset(doqProtocolError)
+		return
+	}
+
+	// شناسه پیام باید صفر باشد و keepalive در DoQ مجاز نیست
+	if len(req) < 12 || binary.BigEndian.Uint16(req) != 0 || edns.WantsKeepalive(req) {
+		conn.Abort(&quic.ApplicationError{Code: doqProtocolError})
+		return
+	}
+
+	state := conn.ConnectionState()
+	resp, err := resolve(ctx, s.resolver, &types.Request{
+		Msg:       req,
+		Client:    conn.RemoteAddr(),
+		Transport: types.TransportDoQ,
+		TLS:       &state,
+	})
+	if err != nil {
+		stream.Reset(doqInternalError)
+		return
+	}
+	if resp.Drop {
+		stream.Reset(doqNoError)
+		return
+	}
+
+	if err := writeMsg(stream, resp.Msg); err != nil {
+		stream.Reset(doqInternalError)
+		return
+	}
+	stream.Close()
+}
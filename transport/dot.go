@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"dns-server/types"
+	"net"
+	"time"
+)
+
+// DoTServer serves DNS over TLS (RFC 7858) using the same pipelined,
+// length-prefixed connection handling as TCP, with a longer idle timeout
+// since the TLS handshake is expensive to repeat.
+type DoTServer struct {
+	addr     string
+	resolver types.Resolver
+	certFile string
+	keyFile  string
+	limits   connLimits
+	conns    chan struct{}
+	listener *streamListener
+}
+
+func NewDoTServer(
+	addr string,
+	r types.Resolver,
+	certFile string,
+	keyFile string,
+) *DoTServer {
+	limits := defaultConnLimits
+	limits.idleTimeout = 30 * time.Second
+
+	return &DoTServer{
+		addr:     addr,
+		resolver: r,
+		certFile: certFile,
+		keyFile:  keyFile,
+		limits:   limits,
+		conns:    make(chan struct{}, limits.maxConns),
+		listener: newStreamListener(),
+	}
+}
+
+func (s *DoTServer) ListenAndServe() error {
+	certs, err := newCertReloader(s.certFile, s.keyFile)
+	if err != nil {
+		return err
+	}
+
+	ln, err := tls.Listen("tcp", s.addr, certs.tlsConfig("dot"))
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	return s.listener.serve(ln, s.handleConn)
+}
+
+// Shutdown stops accepting connections and drains open ones like
+// TCPServer.Shutdown.
+func (s *DoTServer) Shutdown(ctx context.Context) error {
+	return s.listener.shutdown(ctx)
+}
+
+func (s *DoTServer) handleConn(conn net.Conn, done <-chan struct{}) {
+	select {
+	case s.conns <- struct{}{}:
+		defer func() { <-s.conns }()
+	default:
+		conn.Close()
+		return
+	}
+
+	// مهلت اولین خواندن در serveConn، handshake را هم پوشش می‌دهد
+	serveConn(conn, s.resolver, types.TransportDoT, s.limits, done)
+}
@@ -0,0 +1,27 @@
+package transport
+
+import "golang.org/x/net/dns/dnsmessage"
+
+// udpPayload returns the largest UDP response the client accepts: the size
+// in its OPT record, or 512 bytes without EDNS(0).
+func udpPayload(req []byte) int {
+	var p dnsmessage.Parser
+	if _, err := p.Start(req); err != nil {
+		return 512
+	}
+	if p.SkipAllQuestions() != nil || p.SkipAllAnswers() != nil || p.SkipAllAuthorities() != nil {
+		return 512
+	}
+	for {
+		h, err := p.AdditionalHeader()
+		if err != nil {
+			return 512
+		}
+		if h.Type == dnsmessage.TypeOPT {
+			return max(512, min(int(h.Class), maxUDPSize))
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return 512
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"context"
+	"dns-server/edns"
+	"dns-server/types"
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// resolve passes req to r, using ResolveRequest when r supports it and
+// falling back to the byte oriented Resolve with req stored in ctx. A panic
+// in r is logged with its stack trace, so the bug gets noticed, and only
+// that query is dropped.
+func resolve(ctx context.Context, r types.Resolver, req *types.Request) (resp *types.Response, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("PANIC while resolving %x from %s: %v\n%s", req.Msg, req.Client, p, debug.Stack())
+			resp, err = nil, fmt.Errorf("resolver panic: %v", p)
+		}
+	}()
+
+	if rr, ok := r.(types.RequestResolver); ok {
+		return rr.ResolveRequest(ctx, req)
+	}
+
+	msg, err := r.Resolve(types.WithRequest(ctx, req), req.Msg)
+	if err != nil {
+		return nil, err
+	}
+	msg = edns.WithKeepalive(req.Msg, msg, req.Keepalive)
+	if req.MaxSize > 0 {
+		if msg, err = edns.Fit(msg, req.MaxSize); err != nil {
+			return nil, err
+		}
+	}
+	resp = &types.Response{Msg: msg}
+	if len(msg) >= 4 {
+		resp.RCode = int(msg[3] & 0x0f)
+	}
+	return resp, nil
+}
@@ -0,0 +1,128 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrServerClosed is returned by ListenAndServe after Shutdown has been
+// called, in the same way as http.ErrServerClosed.
+var ErrServerClosed = errors.New("transport: server closed")
+
+// streamListener runs the accept loop of a connection oriented server
+// (TCP, DoT) and keeps track of open connections so they can be drained
+// on shutdown.
+type streamListener struct {
+	mu      sync.Mutex
+	ln      net.Listener
+	conns   map[net.Conn]struct{}
+	wg      sync.WaitGroup
+	closing bool
+	done    chan struct{}
+}
+
+func newStreamListener() *streamListener {
+	return &streamListener{done: make(chan struct{})}
+}
+
+// serve accepts connections on ln until shutdown. handle receives a
+// channel that is closed when the server starts shutting down.
+func (l *streamListener) serve(ln net.Listener, handle func(net.Conn, <-chan struct{})) error {
+	l.mu.Lock()
+	if l.closing {
+		l.mu.Unlock()
+		ln.Close()
+		return ErrServerClosed
+	}
+	l.ln = ln
+	l.conns = make(map[net.Conn]struct{})
+	l.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if l.isClosing() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			continue
+		}
+
+		l.mu.Lock()
+		if l.closing {
+			// shutdown ممکن است منتظر wg باشد؛ اتصال جدید شمرده نمی‌شود
+			l.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		l.conns[conn] = struct{}{}
+		l.wg.Add(1)
+		l.mu.Unlock()
+
+		go func() {
+			defer func() {
+				l.mu.Lock()
+				delete(l.conns, conn)
+				l.mu.Unlock()
+				l.wg.Done()
+			}()
+			handle(conn, l.done)
+		}()
+	}
+}
+
+func (l *streamListener) isClosing() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closing
+}
+
+// shutdown stops accepting, makes every connection stop reading new
+// queries and waits for in-flight responses to be written. Connections
+// still open when ctx expires are closed forcibly.
+func (l *streamListener) shutdown(ctx context.Context) error {
+	l.mu.Lock()
+	if !l.closing {
+		l.closing = true
+		close(l.done)
+	}
+	if l.ln != nil {
+		l.ln.Close()
+	}
+	for conn := range l.conns {
+		// خواندن بعدی فوراً خطا می‌دهد؛ پاسخ‌های در حال ارسال کامل می‌شوند
+		conn.SetReadDeadline(time.Now())
+	}
+	l.mu.Unlock()
+
+	if err := waitGroupContext(ctx, &l.wg); err != nil {
+		l.mu.Lock()
+		for conn := range l.conns {
+			conn.Close()
+		}
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// waitGroupContext waits for wg or returns ctx.Err() if ctx ends first.
+func waitGroupContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
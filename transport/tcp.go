@@ -3,22 +3,46 @@ package transport
 
 import (
 	"context"
+	"crypto/tls"
 	"dns-server/types"
 	"encoding/binary"
 	"io"
 	"net"
+	"sync"
 	"time"
 )
 
+// connLimits bounds how a persistent stream connection (TCP or DoT) is
+// used, following RFC 7766.
+type connLimits struct {
+	idleTimeout time.Duration // بستن اتصال پس از این مدت بیکاری
+	maxQueries  int           // حداکثر تعداد پرسش در هر اتصال
+	maxInflight int           // حداکثر پرسش‌های همزمان در هر اتصال
+	maxConns    int           // حداکثر اتصال‌های همزمان سرور
+}
+
+var defaultConnLimits = connLimits{
+	idleTimeout: 10 * time.Second,
+	maxQueries:  100,
+	maxInflight: 16,
+	maxConns:    1000,
+}
+
 type TCPServer struct {
 	addr     string
 	resolver types.Resolver
+	limits   connLimits
+	conns    chan struct{}
+	listener *streamListener
 }
 
 func NewTCPServer(addr string, r types.Resolver) *TCPServer {
 	return &TCPServer{
 		addr:     addr,
 		resolver: r,
+		limits:   defaultConnLimits,
+		conns:    make(chan struct{}, defaultConnLimits.maxConns),
+		listener: newStreamListener(),
 	}
 }
 
@@ -29,42 +53,126 @@ func (s *TCPServer) ListenAndServe() error {
 	}
 	defer ln.Close()
 
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			continue
-		}
-		go s.handleConn(conn)
+	return s.listener.serve(ln, s.handleConn)
+}
+
+// Shutdown stops accepting connections, lets open connections finish the
+// queries already read and closes them, or gives up when ctx expires.
+func (s *TCPServer) Shutdown(ctx context.Context) error {
+	return s.listener.shutdown(ctx)
+}
+
+func (s *TCPServer) handleConn(conn net.Conn, done <-chan struct{}) {
+	select {
+	case s.conns <- struct{}{}:
+		defer func() { <-s.conns }()
+	default:
+		// ظرفیت اتصال‌ها پر است
+		conn.Close()
+		return
 	}
+
+	serveConn(conn, s.resolver, types.TransportTCP, s.limits, done)
 }
 
-func (s *TCPServer) handleConn(conn net.Conn) {
+// serveConn reads pipelined queries from conn until the client closes it,
+// it stays idle for too long or maxQueries is reached. Queries are
+// resolved concurrently and each response is written as soon as it is
+// ready, so replies may be sent out of order; clients match them by ID.
+// Closing done stops reading further queries.
+func serveConn(
+	conn net.Conn,
+	r types.Resolver,
+	transport types.Transport,
+	l connLimits,
+	done <-chan struct{},
+) {
 	defer conn.Close()
-	conn.SetDeadline(time.Now().Add(10 * time.Second))
 
+	var (
+		wg      sync.WaitGroup
+		writeMu sync.Mutex
+		sem     = make(chan struct{}, l.maxInflight)
+		client  = types.AddrPortOf(conn.RemoteAddr())
+	)
+
+	for n := 0; n < l.maxQueries; n++ {
+		conn.SetReadDeadline(time.Now().Add(l.idleTimeout))
+		// بررسی پس از تنظیم مهلت تا با shutdown همزمان رقابت نکند
+		select {
+		case <-done:
+			conn.SetReadDeadline(time.Now())
+		default:
+		}
+		msg, err := readMsg(conn)
+		if err != nil {
+			break
+		}
+
+		req := &types.Request{
+			Msg:       msg,
+			Client:    client,
+			Transport: transport,
+			Keepalive: l.idleTimeout,
+		}
+		if tc, ok := conn.(*tls.Conn); ok {
+			// پس از اولین خواندن، handshake انجام شده است
+			state := tc.ConnectionState()
+			req.TLS = &state
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			resp, err := resolve(ctx, r, req)
+			if err != nil || resp.Drop {
+				return
+			}
+
+			writeMu.Lock()
+			defer writeMu.Unlock()
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := writeMsg(conn, resp.Msg); err != nil {
+				// با بستن اتصال، حلقه خواندن هم متوقف می‌شود
+				conn.Close()
+			}
+		}()
+	}
+
+	// پاسخ پرسش‌های در حال اجرا قبل از بستن اتصال ارسال می‌شود
+	wg.Wait()
+}
+
+// readMsg reads one DNS message framed with a 2-byte big-endian length
+// prefix, as used by TCP (RFC 1035 4.2.2), DoT and DoQ.
+func readMsg(r io.Reader) ([]byte, error) {
 	// خواندن طول پیام (2 بایت Big Endian)
 	var length uint16
-	if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
-		return
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
 	}
 
 	// خواندن بدنه پیام
 	buf := make([]byte, length)
-	if _, err := io.ReadFull(conn, buf); err != nil {
-		return
-	}
-
-	// resolve کردن
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	resp, err := s.resolver.Resolve(ctx, buf)
-	if err != nil {
-		return
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
 	}
+	return buf, nil
+}
 
+// writeMsg writes msg with its length prefix in a single Write so that
+// the framing and body are not split across TLS records or segments.
+func writeMsg(w io.Writer, msg []byte) error {
 	// نوشتن پاسخ (طول + بدنه)
-	respLength := uint16(len(resp))
-	binary.Write(conn, binary.BigEndian, respLength)
-	conn.Write(resp)
+	out := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(out, uint16(len(msg)))
+	copy(out[2:], msg)
+	_, err := w.Write(out)
+	return err
 }
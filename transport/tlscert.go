@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader serves a certificate pair from disk and picks up renewed
+// files without a restart. The files are checked at most once per
+// checkInterval, from inside the TLS handshake.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+const checkInterval = 10 * time.Second
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	c := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert = &cert
+	c.modTime = c.latestModTime()
+	c.checked = time.Now()
+	return nil
+}
+
+func (c *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, f := range []string{c.certFile, c.keyFile} {
+		if st, err := os.Stat(f); err == nil && st.ModTime().After(latest) {
+			latest = st.ModTime()
+		}
+	}
+	return latest
+}
+
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) >= checkInterval {
+		c.checked = time.Now()
+		if c.latestModTime().After(c.modTime) {
+			// در صورت خطا گواهی قبلی حفظ می‌شود
+			if err := c.reload(); err != nil {
+				log.Printf("tls: reload %s: %v", c.certFile, err)
+			} else {
+				log.Printf("tls: reloaded %s", c.certFile)
+			}
+		}
+	}
+
+	return c.cert, nil
+}
+
+// tlsConfig returns a server config backed by the reloader. Session
+// tickets stay enabled so clients can resume; crypto/tls rotates the
+// ticket keys on its own.
+func (c *certReloader) tlsConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+		NextProtos:     nextProtos,
+	}
+}
@@ -2,38 +2,195 @@ package transport
 
 import (
 	"context"
+	"dns-server/edns"
+	"dns-server/rrl"
 	"dns-server/types"
+	"errors"
 	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// maxUDPSize is the largest query accepted over UDP; it covers EDNS(0)
+// payload sizes advertised by common clients.
+const maxUDPSize = 4096
+
+var udpBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, maxUDPSize)
+		return &b
+	},
+}
+
+type udpPacket struct {
+	conn net.PacketConn
+	addr net.Addr
+	buf  *[]byte
+	n    int
+}
+
+// UDPServer reads packets on one or more sockets and hands them to a fixed
+// pool of workers through a bounded queue. When the queue is full new
+// packets are dropped instead of spawning unbounded goroutines.
 type UDPServer struct {
 	addr     string
 	resolver types.Resolver
+
+	sockets   int
+	workers   int
+	queueSize int
+
+	dropped atomic.Uint64
+	limiter *rrl.Limiter
+
+	mu      sync.Mutex
+	conns   []net.PacketConn
+	closing bool
+	running sync.WaitGroup
 }
 
 func NewUDPServer(addr string, r types.Resolver) *UDPServer {
 	return &UDPServer{
-		addr:     addr,
-		resolver: r,
+		addr:      addr,
+		resolver:  r,
+		sockets:   1,
+		workers:   64 * runtime.NumCPU(),
+		queueSize: 1024,
+	}
+}
+
+// SetSockets sets how many sockets are bound to the address. Values above
+// one use SO_REUSEPORT so the kernel spreads packets across them; on
+// platforms without it a single socket is used.
+func (s *UDPServer) SetSockets(n int) {
+	if n < 1 || !reusePortSupported {
+		n = 1
 	}
+	s.sockets = n
+}
+
+// SetWorkers sets the number of goroutines resolving queries.
+func (s *UDPServer) SetWorkers(n int) {
+	if n > 0 {
+		s.workers = n
+	}
+}
+
+// SetRateLimiter enables response rate limiting for UDP replies.
+func (s *UDPServer) SetRateLimiter(l *rrl.Limiter) {
+	s.limiter = l
+}
+
+// Dropped returns the number of packets discarded because the worker
+// queue was full.
+func (s *UDPServer) Dropped() uint64 {
+	return s.dropped.Load()
 }
 
 func (s *UDPServer) ListenAndServe() error {
-	conn, err := net.ListenPacket("udp", s.addr)
-	if err != nil {
-		return err
+	lc := net.ListenConfig{}
+	if s.sockets > 1 {
+		lc.Control = reusePortControl
 	}
-	defer conn.Close()
 
-	buf := make([]byte, 512)
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	for i := 0; i < s.sockets; i++ {
+		conn, err := lc.ListenPacket(context.Background(), "udp", s.addr)
+		if err != nil {
+			for _, c := range s.conns {
+				c.Close()
+			}
+			s.conns = nil
+			s.mu.Unlock()
+			return err
+		}
+		s.conns = append(s.conns, conn)
+	}
+	// پیش از آنکه Shutdown سوکت‌ها را ببیند شمرده می‌شوند تا Wait زودتر برنگردد
+	s.running.Add(s.workers)
+	conns := s.conns
+	s.mu.Unlock()
 
+	queue := make(chan udpPacket, s.queueSize)
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			defer s.running.Done()
+			s.worker(queue)
+		}()
+	}
+
+	var readers sync.WaitGroup
+	for _, conn := range conns {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			s.readLoop(conn, queue)
+		}()
+	}
+	readers.Wait()
+
+	// کارگرها صف باقی‌مانده را تمام می‌کنند و خارج می‌شوند
+	close(queue)
+	return ErrServerClosed
+}
+
+// Shutdown stops reading packets and waits for queued and in-flight
+// queries to be answered. The sockets stay open for writing until then.
+func (s *UDPServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	for _, c := range s.conns {
+		// بلافاصله ReadFrom را متوقف می‌کند بدون بستن سوکت
+		c.SetReadDeadline(time.Now())
+	}
+	conns := s.conns
+	s.mu.Unlock()
+
+	err := waitGroupContext(ctx, &s.running)
+	for _, c := range conns {
+		c.Close()
+	}
+	return err
+}
+
+func (s *UDPServer) readLoop(conn net.PacketConn, queue chan<- udpPacket) {
 	for {
-		n, addr, err := conn.ReadFrom(buf)
+		buf := udpBufPool.Get().(*[]byte)
+		n, addr, err := conn.ReadFrom(*buf)
 		if err != nil {
+			udpBufPool.Put(buf)
+			if s.isClosing() || errors.Is(err, net.ErrClosed) {
+				return
+			}
 			continue
 		}
-		go s.handlePacket(conn, addr, buf[:n])
+
+		select {
+		case queue <- udpPacket{conn: conn, addr: addr, buf: buf, n: n}:
+		default:
+			// صف پر است؛ بسته دور ریخته می‌شود تا کلاینت دوباره تلاش کند
+			s.dropped.Add(1)
+			udpBufPool.Put(buf)
+		}
+	}
+}
+
+func (s *UDPServer) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+func (s *UDPServer) worker(queue <-chan udpPacket) {
+	for pkt := range queue {
+		s.handlePacket(pkt.conn, pkt.addr, (*pkt.buf)[:pkt.n])
+		udpBufPool.Put(pkt.buf)
 	}
 }
 
@@ -45,10 +202,34 @@ func (s *UDPServer) handlePacket(
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	resp, err := s.resolver.Resolve(ctx, data)
-	if err != nil {
+	client := types.AddrPortOf(addr)
+	resp, err := resolve(ctx, s.resolver, &types.Request{
+		Msg:       data,
+		Client:    client,
+		Transport: types.TransportUDP,
+		MaxSize:   udpPayload(data),
+	})
+	if err != nil || resp.Drop {
 		return
 	}
 
-	conn.WriteTo(resp, addr)
+	out := resp.Msg
+	if s.limiter != nil {
+		switch s.limiter.Check(client.Addr(), out) {
+		case rrl.Drop:
+			return
+		case rrl.Slip:
+			if out, err = edns.Truncate(out); err != nil {
+				return
+			}
+			// پاسخ کوتاه‌شده به پرسش امضاشده دوباره امضا می‌شود
+			if resp.Resign != nil {
+				if out, err = resp.Resign(out); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	conn.WriteTo(out, addr)
 }
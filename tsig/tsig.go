@@ -0,0 +1,404 @@
+// Package tsig verifies and produces TSIG transaction signatures
+// (RFC 8945) on raw DNS messages.
+package tsig
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"dns-server/dnsname"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+const (
+	typeTSIG  = 250
+	classANY  = 255
+	headerLen = 12
+)
+
+// TSIG error codes carried in the TSIG record (RFC 8945 section 5.3).
+const (
+	ErrCodeBadSig  = 16
+	ErrCodeBadKey  = 17
+	ErrCodeBadTime = 18
+)
+
+var (
+	ErrNoSignature = errors.New("tsig: message is not signed")
+	ErrBadKey      = errors.New("tsig: unknown key or algorithm")
+	ErrBadSig      = errors.New("tsig: signature mismatch")
+	ErrBadTime     = errors.New("tsig: signing time outside fudge")
+	ErrFormat      = errors.New("tsig: malformed message")
+)
+
+// Key is a shared secret identified by its domain name.
+type Key struct {
+	Name      string `json:"name"`
+	Algorithm string `json:"algorithm"` // hmac-sha1, hmac-sha256 یا hmac-sha512
+	Secret    string `json:"secret"`    // base64
+}
+
+func (k Key) hash() (func() hash.Hash, string, error) {
+	switch strings.TrimSuffix(strings.ToLower(k.Algorithm), ".") {
+	case "hmac-sha1":
+		return sha1.New, "hmac-sha1.", nil
+	case "hmac-sha256", "":
+		return sha256.New, "hmac-sha256.", nil
+	case "hmac-sha512":
+		return sha512.New, "hmac-sha512.", nil
+	}
+	return nil, "", fmt.Errorf("tsig: unsupported algorithm %q", k.Algorithm)
+}
+
+// Validate checks the algorithm and secret of k.
+func (k Key) Validate() error {
+	if _, _, err := k.hash(); err != nil {
+		return err
+	}
+	if _, err := base64.StdEncoding.DecodeString(k.Secret); err != nil {
+		return fmt.Errorf("tsig: key %s: invalid secret: %w", k.Name, err)
+	}
+	return nil
+}
+
+// Result describes the signature found on a verified request; it is
+// needed to sign the matching response.
+type Result struct {
+	Key Key
+	MAC []byte
+}
+
+// Verify checks the TSIG record at the end of msg against keys, indexed by
+// key name as returned by dnsname.Fold. It returns ErrNoSignature when
+// msg carries no TSIG record.
+func Verify(msg []byte, keys map[string]Key) (*Result, error) {
+	start, err := lastRecordOffset(msg)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return nil, ErrNoSignature
+	}
+
+	rr, err := parseRecord(msg, start)
+	if err != nil {
+		return nil, err
+	}
+	if rr.rtype != typeTSIG {
+		return nil, ErrNoSignature
+	}
+
+	key, ok := keys[dnsname.Fold(rr.name)]
+	if !ok {
+		return nil, ErrBadKey
+	}
+	newHash, alg, err := key.hash()
+	if err != nil || alg != dnsname.Fold(rr.algorithm) {
+		return nil, ErrBadKey
+	}
+	secret, err := base64.StdEncoding.DecodeString(key.Secret)
+	if err != nil {
+		return nil, ErrBadKey
+	}
+
+	mac := hmac.New(newHash, secret)
+	mac.Write(stripped(msg, start, rr.originalID))
+	mac.Write(variables(rr.name, alg, rr.timeSigned, rr.fudge, rr.errCode, rr.other))
+	if !hmac.Equal(mac.Sum(nil), rr.mac) {
+		return nil, ErrBadSig
+	}
+
+	now := uint64(time.Now().Unix())
+	if diff := int64(now) - int64(rr.timeSigned); diff > int64(rr.fudge) || -diff > int64(rr.fudge) {
+		return nil, ErrBadTime
+	}
+
+	return &Result{Key: key, MAC: rr.mac}, nil
+}
+
+// Sign appends a TSIG record to resp covering the request MAC in req, as
+// required for responses to signed queries.
+func Sign(resp []byte, req *Result) ([]byte, error) {
+	return sign(resp, req.Key, req.MAC, uint64(time.Now().Unix()), 0, nil)
+}
+
+// Reject appends to resp, the NOTAUTH answer to the signed query req, the
+// TSIG record that tells the client why verification failed with verr
+// (RFC 8945 section 5.3). BADKEY and BADSIG carry an empty MAC, since the
+// server cannot sign with a key it does not share; BADTIME is signed and
+// carries the server's clock in its other data.
+func Reject(resp, req []byte, keys map[string]Key, verr error) ([]byte, error) {
+	if len(resp) < headerLen {
+		return nil, ErrFormat
+	}
+	start, err := lastRecordOffset(req)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return nil, ErrNoSignature
+	}
+	rr, err := parseRecord(req, start)
+	if err != nil {
+		return nil, err
+	}
+	if rr.rtype != typeTSIG {
+		return nil, ErrNoSignature
+	}
+
+	now := uint64(time.Now().Unix())
+	switch {
+	case errors.Is(verr, ErrBadTime):
+		key, ok := keys[dnsname.Fold(rr.name)]
+		if !ok {
+			return nil, ErrBadKey
+		}
+		// زمان امضای پرسش برگردانده می‌شود و زمان سرور در other data می‌آید
+		return sign(resp, key, rr.mac, rr.timeSigned, ErrCodeBadTime, appendUint48(nil, now))
+	case errors.Is(verr, ErrBadSig):
+		return appendRecord(resp, dnsname.Fold(rr.name), dnsname.Fold(rr.algorithm), now, nil, ErrCodeBadSig, nil), nil
+	case errors.Is(verr, ErrBadKey):
+		return appendRecord(resp, dnsname.Fold(rr.name), dnsname.Fold(rr.algorithm), now, nil, ErrCodeBadKey, nil), nil
+	}
+	return nil, verr
+}
+
+// fudge is the clock skew allowed by the server's own signatures.
+const fudge = 300
+
+func sign(resp []byte, key Key, reqMAC []byte, timeSigned uint64, errCode uint16, other []byte) ([]byte, error) {
+	if len(resp) < headerLen {
+		return nil, ErrFormat
+	}
+	newHash, alg, err := key.hash()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := base64.StdEncoding.DecodeString(key.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	name := dnsname.Fold(key.Name)
+
+	mac := hmac.New(newHash, secret)
+	var size [2]byte
+	binary.BigEndian.PutUint16(size[:], uint16(len(reqMAC)))
+	mac.Write(size[:])
+	mac.Write(reqMAC)
+	mac.Write(resp)
+	mac.Write(variables(name, alg, timeSigned, fudge, errCode, other))
+
+	return appendRecord(resp, name, alg, timeSigned, mac.Sum(nil), errCode, other), nil
+}
+
+// appendRecord appends a TSIG record to resp and counts it in ARCOUNT.
+func appendRecord(resp []byte, name, alg string, timeSigned uint64, mac []byte, errCode uint16, other []byte) []byte {
+	out := make([]byte, 0, len(resp)+64+len(mac)+len(other))
+	out = append(out, resp...)
+	out = appendName(out, name)
+	out = binary.BigEndian.AppendUint16(out, typeTSIG)
+	out = binary.BigEndian.AppendUint16(out, classANY)
+	out = binary.BigEndian.AppendUint32(out, 0)
+
+	rdata := appendName(nil, alg)
+	rdata = appendUint48(rdata, timeSigned)
+	rdata = binary.BigEndian.AppendUint16(rdata, fudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, resp[0], resp[1]) // original ID
+	rdata = binary.BigEndian.AppendUint16(rdata, errCode)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(other)))
+	rdata = append(rdata, other...)
+
+	out = binary.BigEndian.AppendUint16(out, uint16(len(rdata)))
+	out = append(out, rdata...)
+
+	arcount := binary.BigEndian.Uint16(out[10:])
+	binary.BigEndian.PutUint16(out[10:], arcount+1)
+	return out
+}
+
+type record struct {
+	name       string
+	rtype      uint16
+	algorithm  string
+	timeSigned uint64
+	fudge      uint16
+	mac        []byte
+	originalID uint16
+	errCode    uint16
+	other      []byte
+}
+
+func parseRecord(msg []byte, off int) (*record, error) {
+	name, off, err := readName(msg, off)
+	if err != nil {
+		return nil, err
+	}
+	if off+10 > len(msg) {
+		return nil, ErrFormat
+	}
+	rr := &record{name: name, rtype: binary.BigEndian.Uint16(msg[off:])}
+	rdlen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	end := off + rdlen
+	if end != len(msg) {
+		return nil, ErrFormat
+	}
+	if rr.rtype != typeTSIG {
+		return rr, nil
+	}
+
+	if rr.algorithm, off, err = readName(msg, off); err != nil {
+		return nil, err
+	}
+	if off+10 > end {
+		return nil, ErrFormat
+	}
+	rr.timeSigned = uint64(binary.BigEndian.Uint16(msg[off:]))<<32 | uint64(binary.BigEndian.Uint32(msg[off+2:]))
+	rr.fudge = binary.BigEndian.Uint16(msg[off+6:])
+	macLen := int(binary.BigEndian.Uint16(msg[off+8:]))
+	off += 10
+	if off+macLen+6 > end {
+		return nil, ErrFormat
+	}
+	rr.mac = msg[off : off+macLen]
+	off += macLen
+	rr.originalID = binary.BigEndian.Uint16(msg[off:])
+	rr.errCode = binary.BigEndian.Uint16(msg[off+2:])
+	otherLen := int(binary.BigEndian.Uint16(msg[off+4:]))
+	off += 6
+	if off+otherLen != end {
+		return nil, ErrFormat
+	}
+	rr.other = msg[off:end]
+	return rr, nil
+}
+
+// stripped returns msg without its final record, with ARCOUNT decremented
+// and the original ID restored.
+func stripped(msg []byte, start int, originalID uint16) []byte {
+	out := make([]byte, start)
+	copy(out, msg[:start])
+	binary.BigEndian.PutUint16(out[0:], originalID)
+	arcount := binary.BigEndian.Uint16(out[10:])
+	binary.BigEndian.PutUint16(out[10:], arcount-1)
+	return out
+}
+
+func variables(name, alg string, timeSigned uint64, fudge, errCode uint16, other []byte) []byte {
+	b := appendName(nil, dnsname.Fold(name))
+	b = binary.BigEndian.AppendUint16(b, classANY)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = appendName(b, dnsname.Fold(alg))
+	b = appendUint48(b, timeSigned)
+	b = binary.BigEndian.AppendUint16(b, fudge)
+	b = binary.BigEndian.AppendUint16(b, errCode)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(other)))
+	return append(b, other...)
+}
+
+// lastRecordOffset walks msg and returns the offset of the last record in
+// the additional section, or -1 if that section is empty.
+func lastRecordOffset(msg []byte) (int, error) {
+	if len(msg) < headerLen {
+		return 0, ErrFormat
+	}
+	qd := int(binary.BigEndian.Uint16(msg[4:]))
+	rrs := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+	if binary.BigEndian.Uint16(msg[10:]) == 0 {
+		return -1, nil
+	}
+
+	off := headerLen
+	var err error
+	for i := 0; i < qd; i++ {
+		if off, err = skipName(msg, off); err != nil {
+			return 0, err
+		}
+		off += 4
+	}
+
+	last := -1
+	for i := 0; i < rrs; i++ {
+		last = off
+		if off, err = skipName(msg, off); err != nil {
+			return 0, err
+		}
+		if off+10 > len(msg) {
+			return 0, ErrFormat
+		}
+		off += 10 + int(binary.BigEndian.Uint16(msg[off+8:]))
+	}
+	if off != len(msg) {
+		return 0, ErrFormat
+	}
+	return last, nil
+}
+
+func skipName(msg []byte, off int) (int, error) {
+	for {
+		if off >= len(msg) {
+			return 0, ErrFormat
+		}
+		l := int(msg[off])
+		switch {
+		case l == 0:
+			return off + 1, nil
+		case l&0xc0 == 0xc0:
+			return off + 2, nil
+		case l&0xc0 != 0:
+			return 0, ErrFormat
+		}
+		off += 1 + l
+	}
+}
+
+// readName reads an uncompressed name, as TSIG requires for its owner and
+// algorithm names.
+func readName(msg []byte, off int) (string, int, error) {
+	var sb strings.Builder
+	for {
+		if off >= len(msg) {
+			return "", 0, ErrFormat
+		}
+		l := int(msg[off])
+		if l == 0 {
+			if sb.Len() == 0 {
+				sb.WriteByte('.')
+			}
+			return sb.String(), off + 1, nil
+		}
+		if l&0xc0 != 0 || off+1+l > len(msg) {
+			return "", 0, ErrFormat
+		}
+		sb.Write(msg[off+1 : off+1+l])
+		sb.WriteByte('.')
+		off += 1 + l
+	}
+}
+
+func appendName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
+
+func appendUint48(b []byte, v uint64) []byte {
+	return append(b, byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
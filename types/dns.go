@@ -10,26 +10,38 @@ type RecordType uint16
 type DNSQuestion struct {
 	Name string
 	Type RecordType
+	View string // نام view؛ خالی برای view پیش‌فرض
 }
 
 type DNSRecord struct {
 	Name      string
 	Type      RecordType
-	Value     string
+	View      string
+	Value     string    // متن استاندارد داده رکورد
+	RData     []byte    `json:"-"` // همان داده در قالب wire؛ اگر خالی باشد از Value ساخته می‌شود
 	TTL       uint32    // برای پاسخ
 	ExpiresAt time.Time // برای منطق داخلی
+	Local     bool      // وارده از پنل مدیریت، نه کش پاسخ upstream
+	Generated bool      // PTR ساخته‌شده خودکار برای یک رکورد A/AAAA
 }
 
 type DNSResponse struct {
-	Records []DNSRecord
-	RCode   int
+	Records    []DNSRecord
+	Authority  []DNSRecord // مثل NS برای ارجاع یا SOA برای پاسخ منفی
+	Additional []DNSRecord // مثل رکوردهای glue
+	RCode      int
+	// Authoritative پاسخ ساخته‌شده فقط از داده‌های محلی است (نه upstream یا کش)
+	Authoritative bool
 }
 
 type Storage interface {
 	Get(question DNSQuestion) ([]DNSRecord, bool)
 	Set(record DNSRecord)
-	Delete(name string, rtype RecordType, value string)
+	Delete(view string, name string, rtype RecordType, value string)
 	List() []DNSRecord
+	// Exists reports whether the view has local records (not cached ones)
+	// at name or below it, so that empty non-terminals exist too.
+	Exists(view string, name string) bool
 }
 
 type Resolver interface {
@@ -0,0 +1,82 @@
+package types
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+)
+
+type Transport string
+
+const (
+	TransportUDP  Transport = "udp"
+	TransportTCP  Transport = "tcp"
+	TransportDoT  Transport = "dot"
+	TransportDoQ  Transport = "doq"
+	TransportDoH  Transport = "doh"
+	TransportJSON Transport = "json"
+)
+
+// ErrMalformed is returned for a query too broken to answer, even with
+// FORMERR: one without a complete header.
+var ErrMalformed = errors.New("malformed dns message")
+
+// Request is a DNS query together with what the transport knows about
+// who sent it.
+type Request struct {
+	Msg       []byte
+	Client    netip.AddrPort // صفر برای فراخوانی‌های داخلی
+	Transport Transport
+	TLS       *tls.ConnectionState // برای DoT، DoQ و DoH روی HTTPS
+	HTTP      *http.Request        // برای DoH و JSON
+	Keepalive time.Duration        // برای TCP و DoT: مهلت بیکاری اتصال برای گزینه‌ی edns-tcp-keepalive
+	MaxSize   int                  // برای UDP: بزرگ‌ترین پاسخی که کلاینت می‌پذیرد؛ صفر یعنی بی‌حد
+}
+
+// Response is the resolver's answer to a Request.
+type Response struct {
+	Msg     []byte
+	RCode   int
+	Records []DNSRecord // رکوردهای بخش پاسخ
+	Drop    bool        // پاسخی ارسال نشود (مثلاً سیاست RPZ)
+	// Resign امضای TSIG پاسخ را روی پیام جایگزین آن (مثلاً نسخه‌ی کوتاه‌شده)
+	// تکرار می‌کند؛ برای پاسخ‌های بدون امضا nil است
+	Resign func(msg []byte) ([]byte, error)
+}
+
+// RequestResolver is implemented by resolvers that make decisions based on
+// the client. Transports prefer it over Resolver.Resolve when available.
+type RequestResolver interface {
+	ResolveRequest(ctx context.Context, req *Request) (*Response, error)
+}
+
+type requestKey struct{}
+
+// WithRequest returns a context carrying req, so a plain Resolver.Resolve
+// implementation can still find out about the client.
+func WithRequest(ctx context.Context, req *Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// RequestFromContext returns the request stored by WithRequest.
+func RequestFromContext(ctx context.Context) (*Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(*Request)
+	return req, ok
+}
+
+// AddrPortOf converts the remote address of a connection or packet into a
+// netip.AddrPort.
+func AddrPortOf(addr net.Addr) netip.AddrPort {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.AddrPort()
+	case *net.TCPAddr:
+		return a.AddrPort()
+	}
+	ap, _ := netip.ParseAddrPort(addr.String())
+	return ap
+}
@@ -0,0 +1,34 @@
+package types
+
+import (
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ReverseName returns the in-addr.arpa or ip6.arpa name used for PTR
+// lookups of ip (RFC 1035 3.5, RFC 3596 2.5).
+func ReverseName(ip netip.Addr) string {
+	ip = ip.Unmap()
+	var b strings.Builder
+	if ip.Is4() {
+		a := ip.As4()
+		for i := 3; i >= 0; i-- {
+			b.WriteString(strconv.Itoa(int(a[i])))
+			b.WriteByte('.')
+		}
+		b.WriteString("in-addr.arpa.")
+		return b.String()
+	}
+
+	const hex = "0123456789abcdef"
+	a := ip.As16()
+	for i := 15; i >= 0; i-- {
+		b.WriteByte(hex[a[i]&0x0f])
+		b.WriteByte('.')
+		b.WriteByte(hex[a[i]>>4])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String()
+}
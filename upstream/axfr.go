@@ -0,0 +1,107 @@
+package upstream
+
+import (
+	"crypto/rand"
+	"dns-server/types"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Transfer fetches every record of zone from server with AXFR over TCP.
+// The SOA records that open and close the transfer are not returned.
+func Transfer(server string, zone string) ([]types.DNSRecord, error) {
+	name, err := dnsmessage.NewName(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := rand.Int(rand.Reader, big.NewInt(65535))
+	if err != nil {
+		return nil, err
+	}
+
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: uint16(id.Int64())},
+		Questions: []dnsmessage.Question{{
+			Name:  name,
+			Type:  dnsmessage.TypeAXFR,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+	packet, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", server, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Minute))
+
+	out := make([]byte, 2+len(packet))
+	binary.BigEndian.PutUint16(out, uint16(len(packet)))
+	copy(out[2:], packet)
+	if _, err := conn.Write(out); err != nil {
+		return nil, err
+	}
+
+	var (
+		records []types.DNSRecord
+		soas    int
+	)
+	for soas < 2 {
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+
+		var p dnsmessage.Parser
+		hdr, err := p.Start(buf)
+		if err != nil {
+			return nil, err
+		}
+		if hdr.ID != msg.Header.ID {
+			return nil, errors.New("axfr: response id mismatch")
+		}
+		if hdr.RCode != dnsmessage.RCodeSuccess {
+			return nil, errors.New("axfr: " + hdr.RCode.String())
+		}
+		if err := p.SkipAllQuestions(); err != nil {
+			return nil, err
+		}
+
+		answers, err := p.AllAnswers()
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) == 0 {
+			return nil, errors.New("axfr: empty response")
+		}
+		for _, a := range answers {
+			if a.Header.Type == dnsmessage.TypeSOA {
+				soas++
+				continue
+			}
+			if soas == 0 {
+				return nil, errors.New("axfr: transfer does not start with SOA")
+			}
+			if rec, ok := convertAnswer(a); ok {
+				records = append(records, rec)
+			}
+		}
+	}
+
+	return records, nil
+}
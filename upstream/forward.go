@@ -2,11 +2,10 @@ package upstream
 
 import (
 	"crypto/rand"
+	"dns-server/rdata"
 	"dns-server/types"
-	"fmt"
 	"math/big"
 	"net"
-	"strings"
 	"time"
 
 	"golang.org/x/net/dns/dnsmessage"
@@ -24,16 +23,25 @@ func NewUDPUpstream(server string) *UDPUpstream {
 	}
 }
 
-func toDNSMessageQuestion(q types.DNSQuestion) dnsmessage.Question {
+func toDNSMessageQuestion(q types.DNSQuestion) (dnsmessage.Question, error) {
+	name, err := dnsmessage.NewName(q.Name)
+	if err != nil {
+		return dnsmessage.Question{}, err
+	}
 	return dnsmessage.Question{
-		Name:  dnsmessage.MustNewName(q.Name),
+		Name:  name,
 		Type:  dnsmessage.Type(q.Type),
 		Class: dnsmessage.ClassINET,
-	}
+	}, nil
 }
 
 func buildQueryPacket(q types.DNSQuestion) ([]byte, uint16, error) {
 
+	question, err := toDNSMessageQuestion(q)
+	if err != nil {
+		return nil, 0, err
+	}
+
 	id, err := rand.Int(rand.Reader, big.NewInt(65535))
 	if err != nil {
 		return nil, 0, err
@@ -44,9 +52,7 @@ func buildQueryPacket(q types.DNSQuestion) ([]byte, uint16, error) {
 			ID:       uint16(id.Int64()),
 			Response: false,
 		},
-		Questions: []dnsmessage.Question{
-			toDNSMessageQuestion(q),
-		},
+		Questions: []dnsmessage.Question{question},
 	}
 
 	buf, err := msg.Pack()
@@ -95,56 +101,52 @@ func parseResponse(buf []byte) (types.DNSResponse, error) {
 	if err != nil {
 		return types.DNSResponse{}, err
 	}
-
-	resp := types.DNSResponse{
-		RCode: int(hdr.RCode),
+	authorities, err := p.AllAuthorities()
+	if err != nil {
+		return types.DNSResponse{}, err
+	}
+	additionals, err := p.AllAdditionals()
+	if err != nil {
+		return types.DNSResponse{}, err
 	}
 
-	for _, ans := range answers {
-		rec, ok := convertAnswer(ans)
-		if ok {
-			resp.Records = append(resp.Records, rec)
+	return types.DNSResponse{
+		Records:    convertAll(answers),
+		Authority:  convertAll(authorities),
+		Additional: convertAll(additionals),
+		RCode:      int(hdr.RCode),
+	}, nil
+}
+
+// convertAll converts a section, skipping records it cannot represent
+// such as OPT.
+func convertAll(section []dnsmessage.Resource) []types.DNSRecord {
+	var out []types.DNSRecord
+	for _, a := range section {
+		if rec, ok := convertAnswer(a); ok {
+			out = append(out, rec)
 		}
 	}
-
-	return resp, nil
+	return out
 }
 
 func convertAnswer(a dnsmessage.Resource) (types.DNSRecord, bool) {
-	rec := types.DNSRecord{
-		Name: a.Header.Name.String(),
-		Type: types.RecordType(a.Header.Type),
-		TTL:  a.Header.TTL,
+	data, err := rdata.FromBody(a.Body)
+	if err != nil {
+		return types.DNSRecord{}, false
 	}
-
-	switch body := a.Body.(type) {
-	case *dnsmessage.AResource:
-		rec.Value = net.IP(body.A[:]).String()
-
-	case *dnsmessage.AAAAResource:
-		rec.Value = net.IP(body.AAAA[:]).String()
-
-	case *dnsmessage.CNAMEResource:
-		rec.Value = body.CNAME.String()
-
-	case *dnsmessage.MXResource:
-		// ذخیره MX به فرمت: "preference mx-server"
-		rec.Value = fmt.Sprintf("%d %s", body.Pref, body.MX.String())
-
-	case *dnsmessage.TXTResource:
-		rec.Value = strings.Join(body.TXT, " ")
-
-	case *dnsmessage.NSResource:
-		rec.Value = body.NS.String()
-
-	case *dnsmessage.PTRResource:
-		rec.Value = body.PTR.String()
-
-	default:
+	wire, err := rdata.Wire(data)
+	if err != nil {
 		return types.DNSRecord{}, false
 	}
 
-	return rec, true
+	return types.DNSRecord{
+		Name:  a.Header.Name.String(),
+		Type:  types.RecordType(a.Header.Type),
+		TTL:   a.Header.TTL,
+		Value: data.String(),
+		RData: wire,
+	}, true
 }
 
 func (u *UDPUpstream) Query(q types.DNSQuestion) (types.DNSResponse, error) {
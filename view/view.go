@@ -0,0 +1,210 @@
+// Package view implements split-horizon DNS: clients are matched to a
+// named view, and each view has its own records, cache and upstreams.
+package view
+
+import (
+	"dns-server/acl"
+	"dns-server/dnsname"
+	"dns-server/tsig"
+	"dns-server/types"
+	"dns-server/upstream"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Default is the name of the view used when no views are configured.
+const Default = ""
+
+type Forward struct {
+	Zone   string `json:"zone"`
+	Server string `json:"server"`
+}
+
+type Match struct {
+	Networks   []string          `json:"networks"`
+	Keys       []string          `json:"keys"`
+	Transports []types.Transport `json:"transports"`
+}
+
+type Config struct {
+	Name     string    `json:"name"`
+	Match    Match     `json:"match"`
+	Upstream string    `json:"upstream"`
+	Forward  []Forward `json:"forward"`
+}
+
+type File struct {
+	Keys  []tsig.Key `json:"keys"`
+	Views []Config   `json:"views"`
+}
+
+type forwarder struct {
+	zone     string
+	upstream types.UpStream
+}
+
+type View struct {
+	Name string
+
+	networks   *acl.List
+	keys       map[string]bool
+	transports map[types.Transport]bool
+
+	upstream   types.UpStream // nil یعنی upstream پیش‌فرض resolver
+	forwarders []forwarder    // مرتب‌شده از طولانی‌ترین zone
+}
+
+// Set holds the configured views in match order.
+type Set struct {
+	views []*View
+	keys  map[string]tsig.Key
+}
+
+// Load reads a JSON view configuration file.
+func Load(path string) (*Set, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var f File
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("view: %s: %w", path, err)
+	}
+	return New(f)
+}
+
+func New(f File) (*Set, error) {
+	s := &Set{keys: make(map[string]tsig.Key)}
+
+	for _, k := range f.Keys {
+		if err := k.Validate(); err != nil {
+			return nil, err
+		}
+		s.keys[dnsname.Fold(k.Name)] = k
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range f.Views {
+		if c.Name == "" || seen[c.Name] {
+			return nil, fmt.Errorf("view: missing or duplicate view name %q", c.Name)
+		}
+		seen[c.Name] = true
+
+		v := &View{Name: c.Name}
+
+		if len(c.Match.Networks) > 0 {
+			l, err := acl.Parse(strings.Join(c.Match.Networks, ","), nil)
+			if err != nil {
+				return nil, fmt.Errorf("view %s: %w", c.Name, err)
+			}
+			v.networks = l
+		}
+
+		if len(c.Match.Keys) > 0 {
+			v.keys = make(map[string]bool)
+			for _, k := range c.Match.Keys {
+				k = dnsname.Fold(k)
+				if _, ok := s.keys[k]; !ok {
+					return nil, fmt.Errorf("view %s: unknown key %s", c.Name, k)
+				}
+				v.keys[k] = true
+			}
+		}
+
+		if len(c.Match.Transports) > 0 {
+			v.transports = make(map[types.Transport]bool)
+			for _, t := range c.Match.Transports {
+				v.transports[t] = true
+			}
+		}
+
+		if c.Upstream != "" {
+			v.upstream = upstream.NewUDPUpstream(c.Upstream)
+		}
+		for _, fw := range c.Forward {
+			zone, err := dnsname.Canonical(fw.Zone)
+			if err != nil {
+				return nil, fmt.Errorf("view %s: forward zone %q: %w", c.Name, fw.Zone, err)
+			}
+			v.forwarders = append(v.forwarders, forwarder{
+				zone:     zone,
+				upstream: upstream.NewUDPUpstream(fw.Server),
+			})
+		}
+		sort.Slice(v.forwarders, func(i, j int) bool {
+			return len(v.forwarders[i].zone) > len(v.forwarders[j].zone)
+		})
+
+		s.views = append(s.views, v)
+	}
+
+	return s, nil
+}
+
+// Keys returns the TSIG keys known to the configuration.
+func (s *Set) Keys() map[string]tsig.Key {
+	if s == nil {
+		return nil
+	}
+	return s.keys
+}
+
+// Names lists the configured views in match order.
+func (s *Set) Names() []string {
+	if s == nil || len(s.views) == 0 {
+		return []string{Default}
+	}
+	names := make([]string, len(s.views))
+	for i, v := range s.views {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// Select returns the first view matching the request. keyName is the
+// verified TSIG key name, or empty for unsigned requests. With no views
+// configured every request gets the default view; otherwise a request
+// that matches no view yields false.
+func (s *Set) Select(req *types.Request, keyName string) (*View, bool) {
+	if s == nil || len(s.views) == 0 {
+		return &View{Name: Default}, true
+	}
+	for _, v := range s.views {
+		if v.matches(req, keyName) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (v *View) matches(req *types.Request, keyName string) bool {
+	if v.networks != nil && (!req.Client.IsValid() || !v.networks.Contains(req.Client.Addr())) {
+		return false
+	}
+	if v.keys != nil && !v.keys[dnsname.Fold(keyName)] {
+		return false
+	}
+	if v.transports != nil && !v.transports[req.Transport] {
+		return false
+	}
+	return true
+}
+
+// Upstream returns the upstream for name: the forwarder of the longest
+// matching zone, the view's own upstream or def.
+func (v *View) Upstream(name string, def types.UpStream) types.UpStream {
+	name = dnsname.Fold(name)
+	for _, fw := range v.forwarders {
+		if name == fw.zone || strings.HasSuffix(name, "."+fw.zone) || fw.zone == "." {
+			return fw.upstream
+		}
+	}
+	if v.upstream != nil {
+		return v.upstream
+	}
+	return def
+}